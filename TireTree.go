@@ -1,8 +1,87 @@
 package regPlus
 
+import "unsafe"
+
+// A node is a node of the trie holding the values of a string variable.
+// Its children are kept in arrays sorted by rune, which leaves allocate
+// nothing for, rather than in a map.
 type node struct {
-	Next map[rune]*node
+	keys []rune  // runes leading to the children, in increasing order
+	kids []*node // children, in the order of keys
 	Cnt  int
+
+	// seq is the registration order of the value ending at the node,
+	// starting at 1. On the root of a tree it is the last seq given out.
+	seq int
+}
+
+// A foldedNode is a node the backtracker reached through a case folded
+// rune and whose own value has not been tried yet.
+type foldedNode node
+
+func (f *foldedNode) unfold() *node {
+	return (*node)(f)
+}
+
+// A chosenNode is a node whose value the backtracker tries on its own,
+// without going on to the longer values below it.
+type chosenNode node
+
+func (c *chosenNode) unchoose() *node {
+	return (*node)(c)
+}
+
+// child returns the child of t reached by r, or nil if there is none.
+func (t *node) child(r rune) *node {
+	if i := t.search(r); i < len(t.keys) && t.keys[i] == r {
+		return t.kids[i]
+	}
+	return nil
+}
+
+// search returns the index of the first key of t not below r.
+func (t *node) search(r rune) int {
+	lo, hi := 0, len(t.keys)
+	for lo < hi {
+		m := int(uint(lo+hi) >> 1)
+		if t.keys[m] < r {
+			lo = m + 1
+		} else {
+			hi = m
+		}
+	}
+	return lo
+}
+
+// addChild returns the child of t reached by r, adding it if needed.
+func (t *node) addChild(r rune) *node {
+	i := t.search(r)
+	if i < len(t.keys) && t.keys[i] == r {
+		return t.kids[i]
+	}
+	n := &node{}
+	t.keys = append(t.keys, 0)
+	copy(t.keys[i+1:], t.keys[i:])
+	t.keys[i] = r
+	t.kids = append(t.kids, nil)
+	copy(t.kids[i+1:], t.kids[i:])
+	t.kids[i] = n
+	return n
+}
+
+// removeChild removes the child of t reached by r.
+func (t *node) removeChild(r rune) {
+	i := t.search(r)
+	if i == len(t.keys) || t.keys[i] != r {
+		return
+	}
+	t.keys = append(t.keys[:i], t.keys[i+1:]...)
+	copy(t.kids[i:], t.kids[i+1:])
+	t.kids[len(t.kids)-1] = nil
+	t.kids = t.kids[:len(t.kids)-1]
+	if len(t.keys) == 0 {
+		t.keys, t.kids = nil, nil
+	}
 }
 
 func (t *node) Insert(strs ...string) {
@@ -13,27 +92,20 @@ func (t *node) Insert(strs ...string) {
 
 // 插入str字符串x次
 func (t *node) InsertWithTimes(str string, x int) {
-	if t.Next == nil {
-		t.Next = map[rune]*node{}
-	}
+	root := t
 	for _, r := range str {
-		v, ok := t.Next[r]
-		if !ok {
-			n := &node{Next: map[rune]*node{}}
-			t.Next[r] = n
-			v = n
-		}
-		t = v
+		t = t.addChild(r)
+	}
+	if t.seq == 0 && t != root {
+		root.seq++
+		t.seq = root.seq
 	}
 	t.Cnt += x
 }
 
 func (t *node) Search(str string) bool {
-	if t.Next == nil {
-		return false
-	}
 	for _, r := range str {
-		t = t.Next[r]
+		t = t.child(r)
 		if t == nil {
 			return false
 		}
@@ -42,11 +114,8 @@ func (t *node) Search(str string) bool {
 }
 
 func (t *node) SearchAndDec(str string) bool {
-	if t.Next == nil {
-		return false
-	}
 	for _, r := range str {
-		t = t.Next[r]
+		t = t.child(r)
 		if t == nil {
 			return false
 		}
@@ -57,3 +126,68 @@ func (t *node) SearchAndDec(str string) bool {
 	}
 	return false
 }
+
+// Remove removes every registration of str from the tree rooted at t and
+// prunes the nodes left without values below them. It reports whether
+// str was registered.
+func (t *node) Remove(str string) bool {
+	runes := []rune(str)
+	path := make([]*node, 1, len(runes)+1)
+	path[0] = t
+	for _, r := range runes {
+		n := path[len(path)-1].child(r)
+		if n == nil {
+			return false
+		}
+		path = append(path, n)
+	}
+	last := path[len(path)-1]
+	if last.Cnt == 0 {
+		return false
+	}
+	last.Cnt = 0
+	if last != t {
+		last.seq = 0
+	}
+	for j := len(runes); j > 0; j-- {
+		n := path[j]
+		if n.Cnt > 0 || len(n.keys) > 0 {
+			break
+		}
+		path[j-1].removeChild(runes[j-1])
+	}
+	return true
+}
+
+// clone returns a deep copy of the tree rooted at t. The arrays of the
+// copy are no larger than needed.
+func (t *node) clone() *node {
+	n := &node{Cnt: t.Cnt, seq: t.seq}
+	if len(t.keys) > 0 {
+		n.keys = append([]rune(nil), t.keys...)
+		n.kids = make([]*node, len(t.kids))
+		for i, child := range t.kids {
+			n.kids[i] = child.clone()
+		}
+	}
+	return n
+}
+
+// total returns the number of values registered in the tree rooted at t,
+// counting each value as many times as it was inserted.
+func (t *node) total() int {
+	n := t.Cnt
+	for _, child := range t.kids {
+		n += child.total()
+	}
+	return n
+}
+
+// memory returns the number of bytes allocated for the tree rooted at t.
+func (t *node) memory() int {
+	n := int(unsafe.Sizeof(*t)) + cap(t.keys)*int(unsafe.Sizeof(rune(0))) + cap(t.kids)*int(unsafe.Sizeof(t))
+	for _, child := range t.kids {
+		n += child.memory()
+	}
+	return n
+}
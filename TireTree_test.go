@@ -13,3 +13,35 @@ func TestTireTree_Insert(t *testing.T) {
 	assert.Equal(t, tree.Search("abcd"), false)
 	assert.Equal(t, tree.Search("he"), false)
 }
+
+func TestTireTree_Remove(t *testing.T) {
+	tree := node{}
+	tree.Insert("abc", "ab", "abd", "abc", "x")
+	assert.Equal(t, tree.Remove("abc"), true)
+	assert.Equal(t, tree.Search("abc"), false)
+	assert.Equal(t, tree.Search("ab"), true)
+	assert.Equal(t, tree.Search("abd"), true)
+	assert.Equal(t, tree.Remove("abc"), false)
+	assert.Equal(t, tree.Remove("a"), false)
+
+	assert.Equal(t, tree.Remove("abd"), true)
+	assert.Equal(t, len(tree.child('a').child('b').kids), 0)
+	assert.Equal(t, tree.Remove("ab"), true)
+	assert.Equal(t, tree.Remove("x"), true)
+	assert.Equal(t, len(tree.kids), 0)
+}
+
+func TestTireTree_Memory(t *testing.T) {
+	tree := node{}
+	empty := tree.memory()
+	tree.Insert("ab")
+	assert.Equal(t, tree.child('a').child('b').memory(), empty)
+	one := tree.memory()
+	assert.Equal(t, one > 3*empty, true)
+
+	// Values sharing a prefix share its nodes.
+	tree.Insert("ac")
+	assert.Equal(t, tree.memory() < 2*one, true)
+	tree.Remove("ac")
+	assert.Equal(t, tree.clone().memory(), one)
+}
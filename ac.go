@@ -0,0 +1,311 @@
+package regPlus
+
+import (
+	"sync/atomic"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/koleter/regPlus/syntax"
+)
+
+// An acMachine is an Aho-Corasick automaton over the values of a string
+// variable. It finds where the values occur in the input, so that the
+// search for a pattern starting with the variable can skip the positions
+// where none of them starts, as the search for a literal prefix does.
+type acMachine struct {
+	states []acState
+	maxLen int // length in runes of the longest value
+}
+
+type acState struct {
+	keys  []rune  // runes of the goto transitions, in increasing order
+	next  []int32 // targets of the goto transitions, in the order of keys
+	fail  int32
+	depth int32 // length in runes of the path from the start state
+	match int32 // length of the longest value ending at the state, or 0
+}
+
+// A valueCache holds what is derived from the values of a string variable
+// for searching: the automata matching them case-sensitively and
+// case-insensitively, and the length of the shortest one. They are
+// recomputed when values are added, as the root of the trie or its seq
+// then change. Removing values leaves them valid, if less precise.
+//
+// They are published in an immutable valueSnapshot through an atomic
+// pointer, so that matchers sharing the variable load them without
+// locking.
+type valueCache struct {
+	snap atomic.Value // *valueSnapshot
+}
+
+// A valueSnapshot holds what is derived from the values of a string
+// variable as they were when it was taken. The length of the shortest
+// value is computed with it, and each automaton on first use; matchers
+// building an automaton concurrently build the same one.
+type valueSnapshot struct {
+	root   *node
+	seq    int
+	minLen int
+	m      [2]atomic.Value // *acMachine, indexed by case folding
+}
+
+// snapshot returns the snapshot of the values of t, taking a new one if
+// they have changed.
+func (c *valueCache) snapshot(t *StringTreeNode) *valueSnapshot {
+	s, _ := c.snap.Load().(*valueSnapshot)
+	if s == nil || s.root != t.root || s.seq != t.root.seq {
+		s = &valueSnapshot{root: t.root, seq: t.root.seq, minLen: minValueLen(t.root)}
+		c.snap.Store(s)
+	}
+	return s
+}
+
+// machine returns the automaton over the values of t, building it if
+// needed. It may be called concurrently and takes no lock.
+func (t *StringTreeNode) machine(fold bool) *acMachine {
+	if t.cache == nil {
+		return newACMachine(t.root, fold)
+	}
+	s := t.cache.snapshot(t)
+	k := 0
+	if fold {
+		k = 1
+	}
+	m, _ := s.m[k].Load().(*acMachine)
+	if m == nil {
+		m = newACMachine(s.root, fold)
+		s.m[k].Store(m)
+	}
+	return m
+}
+
+// minLen returns a lower bound on the length in bytes of the input matched
+// by a value of t, with or without case folding, computing it if the
+// values have changed. It may be called concurrently and takes no lock.
+func (t *StringTreeNode) minLen() int {
+	if t.cache == nil {
+		return minValueLen(t.root)
+	}
+	return t.cache.snapshot(t).minLen
+}
+
+// minValueLen returns the length in bytes of the shortest value below n,
+// counting for each rune the shortest rune of its case folding orbit,
+// or 0 if there is none. As for newACMachine, every node that was given
+// a seq counts as a value.
+func minValueLen(n *node) int {
+	min := -1
+	var walk func(n *node, l int)
+	walk = func(n *node, l int) {
+		if min >= 0 && l >= min {
+			return
+		}
+		if n.seq > 0 {
+			min = l
+			return
+		}
+		for j, child := range n.kids {
+			walk(child, l+foldedRuneLen(n.keys[j]))
+		}
+	}
+	for j, child := range n.kids {
+		walk(child, foldedRuneLen(n.keys[j]))
+	}
+	if min < 0 {
+		return 0
+	}
+	return min
+}
+
+// foldedRuneLen returns the length in bytes of the shortest rune of the
+// case folding orbit of r. utf8.RuneError counts as one byte, the length
+// of an invalid byte of the input that it matches.
+func foldedRuneLen(r rune) int {
+	if r == utf8.RuneError {
+		return 1
+	}
+	l := utf8.RuneLen(r)
+	for f := unicode.SimpleFold(r); f != r; f = unicode.SimpleFold(f) {
+		if fl := utf8.RuneLen(f); fl < l {
+			l = fl
+		}
+	}
+	return l
+}
+
+// newACMachine builds the automaton over the values of the trie rooted at
+// root, folding their runes with foldRune if fold is set. Every node that
+// was given a seq is included, even if its value is no longer registered:
+// the automaton may report more candidates than there are, never fewer.
+func newACMachine(root *node, fold bool) *acMachine {
+	m := &acMachine{states: []acState{{}}}
+	var walk func(n *node, s int32)
+	walk = func(n *node, s int32) {
+		if n.seq > 0 && n != root {
+			d := m.states[s].depth
+			m.states[s].match = d
+			if int(d) > m.maxLen {
+				m.maxLen = int(d)
+			}
+		}
+		for j, child := range n.kids {
+			r := n.keys[j]
+			if fold {
+				r = foldRune(r)
+			}
+			walk(child, m.addGoto(s, r))
+		}
+	}
+	walk(root, 0)
+
+	// Compute the failure transitions breadth first, and with them the
+	// longest value ending at each state through its suffixes.
+	queue := []int32{0}
+	for len(queue) > 0 {
+		s := queue[0]
+		queue = queue[1:]
+		for j, r := range m.states[s].keys {
+			t := m.states[s].next[j]
+			if s != 0 {
+				m.states[t].fail = m.step(m.states[s].fail, r)
+			}
+			if m.states[t].match == 0 {
+				m.states[t].match = m.states[m.states[t].fail].match
+			}
+			queue = append(queue, t)
+		}
+	}
+	return m
+}
+
+// addGoto returns the target of the goto transition of s on r, adding
+// the transition and a new state if needed.
+func (m *acMachine) addGoto(s int32, r rune) int32 {
+	st := &m.states[s]
+	i := searchRunes(st.keys, r)
+	if i < len(st.keys) && st.keys[i] == r {
+		return st.next[i]
+	}
+	t := int32(len(m.states))
+	st.keys = append(st.keys, 0)
+	copy(st.keys[i+1:], st.keys[i:])
+	st.keys[i] = r
+	st.next = append(st.next, 0)
+	copy(st.next[i+1:], st.next[i:])
+	st.next[i] = t
+	m.states = append(m.states, acState{depth: st.depth + 1})
+	return t
+}
+
+// step returns the state reached from s on r.
+func (m *acMachine) step(s int32, r rune) int32 {
+	for {
+		st := &m.states[s]
+		if i := searchRunes(st.keys, r); i < len(st.keys) && st.keys[i] == r {
+			return st.next[i]
+		}
+		if s == 0 {
+			return 0
+		}
+		s = st.fail
+	}
+}
+
+// index returns how far past pos the leftmost occurrence of a value in
+// the input starts, or -1 if there is none. fold must be the setting the
+// automaton was built with. ring is scratch space kept between calls.
+func (m *acMachine) index(i input, pos int, fold bool, ring *[]int) int {
+	n := m.maxLen + 1
+	if cap(*ring) < n {
+		*ring = make([]int, n)
+	}
+	starts := (*ring)[:n]
+	from := pos
+	best, bestK := -1, 0
+	s := int32(0)
+	for k := 0; best < 0 || k < bestK+m.maxLen-1; k++ {
+		r, width := i.step(pos)
+		if width == 0 {
+			break
+		}
+		starts[k%n] = pos
+		if fold {
+			r = foldRune(r)
+		}
+		s = m.step(s, r)
+		if d := int(m.states[s].match); d > 0 {
+			// A value starting before this one ends at most maxLen
+			// runes after it starts; look that far before deciding.
+			if startK := k - d + 1; best < 0 || startK < bestK {
+				best, bestK = starts[startK%n], startK
+			}
+		}
+		pos += width
+	}
+	if best < 0 {
+		return -1
+	}
+	return best - from
+}
+
+// searchRunes returns the index of the first rune of keys not below r.
+func searchRunes(keys []rune, r rune) int {
+	lo, hi := 0, len(keys)
+	for lo < hi {
+		m := int(uint(lo+hi) >> 1)
+		if keys[m] < r {
+			lo = m + 1
+		} else {
+			hi = m
+		}
+	}
+	return lo
+}
+
+// foldRune returns the smallest rune of the case folding orbit of r,
+// which stands for all the runes of the orbit.
+func foldRune(r rune) rune {
+	min := r
+	for f := unicode.SimpleFold(r); f != r; f = unicode.SimpleFold(f) {
+		if f < min {
+			min = f
+		}
+	}
+	return min
+}
+
+// startVar returns the string variable instruction that every match of
+// prog starts with, or nil if there is none.
+func startVar(prog *syntax.Prog) *syntax.Inst {
+	pc := uint32(prog.Start)
+	for {
+		inst := &prog.Inst[pc]
+		switch inst.Op {
+		case syntax.InstNop, syntax.InstCapture, syntax.InstEmptyWidth:
+			pc = inst.Out
+		case syntax.InstStringVar:
+			if syntax.Flags(inst.Arg)&syntax.VarRef != 0 {
+				return nil
+			}
+			return inst
+		default:
+			return nil
+		}
+	}
+}
+
+// varIndex returns how far past pos a value of the string variable that
+// re starts with begins in the input, or -1 if none does.
+func (re *Regexp) varIndex(b *bitState, i input, pos int) int {
+	t := re.vars.stringVar[re.startVar.Str]
+	if t == nil {
+		// Let the match panic on the unregistered variable.
+		return 0
+	}
+	if t.root.Cnt > 0 {
+		// The empty string is a value.
+		return 0
+	}
+	fold := re.stringVarFoldCase(re.startVar)
+	return t.machine(fold).index(i, pos, fold, &b.ring)
+}
@@ -0,0 +1,146 @@
+package regPlus
+
+import (
+	"github.com/stretchr/testify/assert"
+	"math/rand"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestACMachine_Index(t *testing.T) {
+	cases := []struct {
+		name   string
+		values []string
+		fold   bool
+		text   string
+		pos    int
+		expect int
+	}{
+		{
+			"No.1", []string{"he", "she", "his", "hers"}, false, "ushers", 0, 1,
+		},
+		{
+			"No.2", []string{"cd", "abcdef"}, false, "xabcdef", 0, 1,
+		},
+		{
+			"No.3", []string{"cd", "abcdef"}, false, "xabcdex", 0, 3,
+		},
+		{
+			"No.4", []string{"abc"}, false, "ababab", 0, -1,
+		},
+		{
+			"No.5", []string{"abc"}, false, "abcxabc", 1, 3,
+		},
+		{
+			"No.6", []string{"straße"}, true, "x STRASSE STRAßE", 0, 10,
+		},
+		{
+			"No.7", []string{"ǆ"}, true, "aǅ", 0, 1,
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			root := &node{}
+			root.Insert(tt.values...)
+			var ring []int
+			var in inputString
+			in.str = tt.text
+			assert.Equal(t, newACMachine(root, tt.fold).index(&in, tt.pos, tt.fold, &ring), tt.expect)
+		})
+	}
+}
+
+// The positions skipped by the prefilter must not hold matches.
+func TestRegexp_StartVarConformance(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	atoms := []string{"a", "b*", "(a|ab)", "${w}", "${w}?", "\\b", "@{v}"}
+	words := []string{"ab", "ba", "b", "aab", "bbab", "A"}
+	for n := 0; n < 500; n++ {
+		pattern := "${w}"
+		if rnd.Intn(2) == 0 {
+			pattern = "(?i)" + pattern
+		}
+		for j := rnd.Intn(3); j > 0; j-- {
+			pattern += atoms[rnd.Intn(len(atoms))]
+		}
+		text := ""
+		for j := rnd.Intn(20); j > 0; j-- {
+			text += string("abA "[rnd.Intn(4)])
+		}
+		strs := words[:1+rnd.Intn(len(words))]
+		re := MustCompile(pattern)
+		re.RegisterStringVar("w", strs...)
+		re.RegisterRegVar("v", MustCompile("a+"))
+		assert.NotNil(t, re.startVar)
+		plain := re.Copy()
+		plain.startVar = nil
+		assert.Equal(t, re.FindAllStringIndex(text, -1), plain.FindAllStringIndex(text, -1), "%s on %q with %q", pattern, text, strs)
+
+		reader, err := re.FindReaderIndexErr(strings.NewReader(text))
+		assert.Nil(t, err)
+		assert.Equal(t, reader, plain.FindStringIndex(text), "%s on %q with %q", pattern, text, strs)
+	}
+}
+
+func TestRegexp_StartVarUpdates(t *testing.T) {
+	mustCompile := MustCompile("${word}!")
+	mustCompile.RegisterStringVar("word", "ab")
+	assert.Equal(t, mustCompile.FindAllString("ab! cd! ef!", -1), []string{"ab!"})
+	mustCompile.RegisterStringVar("word", "cd")
+	assert.Equal(t, mustCompile.FindAllString("ab! cd! ef!", -1), []string{"ab!", "cd!"})
+	mustCompile.ReplaceStringVar("word", "ef")
+	assert.Equal(t, mustCompile.FindAllString("ab! cd! ef!", -1), []string{"ef!"})
+
+	assert.Nil(t, MustCompile("a${word}").startVar)
+	assert.Nil(t, MustCompile("${word}|a").startVar)
+	assert.NotNil(t, MustCompile(`(\b${word})`).startVar)
+}
+
+func TestRegexp_StartVarConcurrent(t *testing.T) {
+	mustCompile := MustCompile("${word}!")
+	mustCompile.RegisterStringVar("word", "ab", "cd")
+	mustCompile.SetStringVarFoldCase("word", true)
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				assert.Equal(t, mustCompile.FindStringIndex("xx AB! cd!"), []int{3, 6})
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func BenchmarkStartVar(b *testing.B) {
+	rnd := rand.New(rand.NewSource(1))
+	words := make([]string, 1000)
+	for i := range words {
+		w := make([]byte, 4+rnd.Intn(6))
+		for j := range w {
+			w[j] = byte('a' + rnd.Intn(26))
+		}
+		words[i] = string(w)
+	}
+	text := strings.Repeat("the quick brown fox jumps over the lazy dog ", 1<<10) + words[0] + "!"
+	re := MustCompile("${word}!")
+	re.RegisterStringVar("word", words...)
+	plain := re.Copy()
+	plain.startVar = nil
+	for _, bm := range []struct {
+		name string
+		re   *Regexp
+	}{{"prefilter", re}, {"scan", plain}} {
+		b.Run(bm.name, func(b *testing.B) {
+			b.SetBytes(int64(len(text)))
+			for i := 0; i < b.N; i++ {
+				if bm.re.FindStringIndex(text) == nil {
+					b.Fatal("no match")
+				}
+			}
+		})
+	}
+}
@@ -16,17 +16,54 @@ package regPlus
 
 import (
 	"github.com/koleter/regPlus/syntax"
+	"math"
+	"sort"
+	"unicode"
 )
 
 // A job is an entry on the backtracker's job stack. It holds
-// the instruction pc and the position in the input.
+// the instruction pc and the position in the input, or the undo
+// of a change to the variable state.
 type job struct {
 	pc  uint32
 	arg bool
 	pos int
 
-	aux interface{}
-	f   func()
+	aux  interface{}
+	undo undoOp
+}
+
+// An undoOp is the kind of a job restoring the variable state changed
+// on the current path once the path is abandoned. Together these jobs
+// form an undo log interleaved with the other jobs, so that each change
+// is undone before the jobs pushed ahead of it run. The operand of the
+// undo, if any, is held in aux.
+type undoOp uint8
+
+const (
+	noUndo       undoOp = iota
+	undoUse             // remove the innermost occurrence of uses
+	undoValue           // give back the value ending at the *node
+	undoStrCount        // uncount the *StringTreeNode; arg if it is free
+	undoRegCount        // uncount the *RegNode; arg if it is free
+	undoRegValue        // give back the reg variable value *Element
+)
+
+// undo reverts the change to the variable state recorded by j.
+func (b *bitState) undo(j *job) {
+	switch j.undo {
+	case undoUse:
+		b.setUseEnd(-1)
+		b.uses = b.uses[:len(b.uses)-1]
+	case undoValue:
+		b.vars.use(j.aux.(*node), -1)
+	case undoStrCount:
+		b.vars.countStr(j.aux.(*StringTreeNode), -1, j.arg)
+	case undoRegCount:
+		b.vars.countReg(j.aux.(*RegNode), -1, j.arg)
+	case undoRegValue:
+		b.vars.useReg(j.aux.(*Element), false)
+	}
 }
 
 const (
@@ -43,24 +80,109 @@ type bitState struct {
 	jobs     []job
 	visited  []uint32
 
+	// visited covers the visitedLen input positions from visitedStart.
+	// It covers the whole input unless the bit vector for
+	// (length of input) * (length of prog) would exceed maxBacktrackVector
+	// shifted left by widen of the outermost state; only programs with
+	// variables are run on such inputs. The window then slides forward
+	// with the match attempts, and a search reaching past it is pruned
+	// and sets overflow on the outermost state, whose attempt is rerun
+	// with a window twice as large.
+	visitedStart int
+	visitedLen   int
+	widen        uint
+	overflow     bool
+
+	// varVisited records the visited (pc, pos) combinations reached
+	// while some variable has been used. Those depend on how the
+	// variables have been consumed, so they are keyed by vars.fp too.
+	varVisited map[varVisit]struct{}
+
+	// vars is the variable bookkeeping of this match. It is never
+	// shared with other calls, so matching does not modify the Regexp.
+	vars *varState
+
+	// uses is the stack of variable occurrences on the current path,
+	// matchUses a copy of it taken when matchcap was recorded.
+	uses      []varUse
+	matchUses []varUse
+
+	// refs is the set of string variables repeated by ${=name}. The
+	// values bound to them steer the rest of the match, so boundFp,
+	// a fingerprint of their occurrences in uses, joins vars.fp in
+	// the keys of varVisited.
+	refs    map[string]bool
+	boundFp uint64
+
+	// nested holds the states created for reg variable searches
+	// during this match; they are released together with it.
+	nested []*bitState
+
+	// re is the Regexp searched by this state and start the start of
+	// its current match attempt. parent is the state whose reg variable
+	// this state searches a value for, depth the number of such
+	// enclosing states, which may not exceed maxDepth.
+	re       *Regexp
+	start    int
+	parent   *bitState
+	depth    int
+	maxDepth int
+
+	// ring is scratch space for locating the values of re.startVar.
+	ring []int
+
+	// limit stops the search when it exceeds the match budget or its
+	// context is done; nil if there is neither.
+	limit *matchLimit
+
 	inputs inputs
 }
 
-//var bitStatePool sync.Pool
+// newBitState returns a state for searching re, reusing one released
+// by an earlier search of re if possible.
+func (re *Regexp) newBitState() *bitState {
+	b, ok := re.bitStates.Get().(*bitState)
+	if !ok {
+		b = new(bitState)
+	}
+	b.vars = newVarState()
+	b.re = re
+	b.refs = re.refs
+	return b
+}
 
-func newBitState() *bitState {
-	return new(bitState)
+// freeBitState releases b, its variable state and the states of all
+// reg variable searches started on its behalf, returning them to the
+// pools of their Regexps. The jobs, visited sets and occurrence stacks
+// keep their memory for the next search.
+func freeBitState(b *bitState) {
+	for _, n := range b.nested {
+		freeBitState(n)
+	}
+	for j := range b.nested {
+		b.nested[j] = nil
+	}
+	b.nested = b.nested[:0]
+	freeVarState(b.vars)
+	b.vars = nil
+	b.inputs.clear()
+	re := b.re
+	b.re, b.refs, b.parent, b.limit = nil, nil, nil, nil
+	b.start, b.depth, b.maxDepth = 0, 0, 0
+	re.bitStates.Put(b)
 }
 
-func (re *Regexp) freeBitState() {
-	b := re.b
-	jobs := b.jobs
-	for i := len(b.jobs) - 1; i >= 0; i-- {
-		if jobs[i].f != nil {
-			jobs[i].f()
-		}
+// clearVars discards the variable state of the attempts made so far, along
+// with the states of their reg variable searches, for a new search over
+// the same input. reset drops the jobs that would undo it.
+func (b *bitState) clearVars() {
+	for j, n := range b.nested {
+		freeBitState(n)
+		b.nested[j] = nil
 	}
-	re.finalFree()
+	b.nested = b.nested[:0]
+	freeVarState(b.vars)
+	b.vars = newVarState()
 }
 
 // maxBitStateLen returns the maximum length of a string to search with
@@ -78,6 +200,35 @@ func shouldBacktrack(prog *syntax.Prog) bool {
 	return len(prog.Inst) <= maxBacktrackProg
 }
 
+// hasVar reports whether prog contains string or reg variable
+// instructions. Only the backtracker can execute those, so such
+// programs are backtracked regardless of their size and the input length.
+func hasVar(prog *syntax.Prog) bool {
+	for i := range prog.Inst {
+		switch prog.Inst[i].Op {
+		case syntax.InstStringVar, syntax.InstRegVar:
+			return true
+		}
+	}
+	return false
+}
+
+// varRefs returns the names of the string variables whose bound value
+// is repeated by ${=name} in prog, or nil if there are none.
+func varRefs(prog *syntax.Prog) map[string]bool {
+	var refs map[string]bool
+	for i := range prog.Inst {
+		inst := &prog.Inst[i]
+		if inst.Op == syntax.InstStringVar && syntax.Flags(inst.Arg)&syntax.VarRef != 0 {
+			if refs == nil {
+				refs = map[string]bool{}
+			}
+			refs[inst.Str] = true
+		}
+	}
+	return refs
+}
+
 // reset resets the state of the backtracker.
 // end is the end position in the input.
 // ncap is the number of captures.
@@ -90,15 +241,12 @@ func (b *bitState) reset(prog *syntax.Prog, end int, ncap int) {
 		b.jobs = b.jobs[:0]
 	}
 
-	visitedSize := (len(prog.Inst)*(end+1) + visitedBits - 1) / visitedBits
-	if cap(b.visited) < visitedSize {
-		b.visited = make([]uint32, visitedSize, maxBacktrackVector/visitedBits)
-	} else {
-		b.visited = b.visited[:visitedSize]
-		for i := range b.visited {
-			b.visited[i] = 0
-		}
-	}
+	b.widen = 0
+	b.overflow = false
+	b.clearVisited(prog, 0)
+	b.uses = b.uses[:0]
+	b.matchUses = b.matchUses[:0]
+	b.boundFp = 0
 
 	if cap(b.cap) < ncap {
 		b.cap = make([]int, ncap)
@@ -119,10 +267,80 @@ func (b *bitState) reset(prog *syntax.Prog, end int, ncap int) {
 	}
 }
 
+// clearVisited empties the visited sets, making the bit vector cover the
+// input positions from start on, as many of them as the window allows.
+func (b *bitState) clearVisited(prog *syntax.Prog, start int) {
+	b.visitedStart = start
+	b.visitedLen = b.end - start + 1
+	if window := b.window(prog); b.visitedLen > window {
+		b.visitedLen = window
+	}
+
+	visitedSize := (len(prog.Inst)*b.visitedLen + visitedBits - 1) / visitedBits
+	if cap(b.visited) < visitedSize {
+		n := maxBacktrackVector / visitedBits
+		if n < visitedSize {
+			n = visitedSize
+		}
+		b.visited = make([]uint32, visitedSize, n)
+	} else {
+		b.visited = b.visited[:visitedSize]
+		for i := range b.visited {
+			b.visited[i] = 0
+		}
+	}
+
+	for k := range b.varVisited {
+		delete(b.varVisited, k)
+	}
+}
+
+// window returns the number of input positions the bit vector of b
+// may cover for prog.
+func (b *bitState) window(prog *syntax.Prog) int {
+	n := maxBacktrackVector / len(prog.Inst)
+	if n < 1 {
+		n = 1
+	}
+	return n << b.outermost().widen
+}
+
+// outermost returns the state of the match b searches a reg variable
+// value for, or b itself if it is that match.
+func (b *bitState) outermost() *bitState {
+	for b.parent != nil {
+		b = b.parent
+	}
+	return b
+}
+
+// A varVisit is a backtracker state reached with variables in use.
+type varVisit struct {
+	pc  uint32
+	pos int
+	fp  uint64
+}
+
 // shouldVisit reports whether the combination of (pc, pos) has not
-// been visited yet.
+// been visited yet with the current variable state.
 func (b *bitState) shouldVisit(pc uint32, pos int) bool {
-	n := uint(int(pc)*(b.end+1) + pos)
+	if fp := b.vars.fp ^ b.boundFp; fp != 0 {
+		if b.varVisited == nil {
+			b.varVisited = make(map[varVisit]struct{})
+		}
+		k := varVisit{pc: pc, pos: pos, fp: fp}
+		if _, ok := b.varVisited[k]; ok {
+			return false
+		}
+		b.varVisited[k] = struct{}{}
+		return true
+	}
+	if pos < b.visitedStart || pos >= b.visitedStart+b.visitedLen {
+		// Past the window: prune the search and have it rerun.
+		b.outermost().overflow = true
+		return false
+	}
+	n := uint(int(pc)*b.visitedLen + pos - b.visitedStart)
 	if b.visited[n/visitedBits]&(1<<(n&(visitedBits-1))) != 0 {
 		return false
 	}
@@ -140,12 +358,153 @@ func (b *bitState) push(re *Regexp, pc uint32, pos int, arg bool) {
 	}
 }
 
+// pushUse records the start of an occurrence of the variable inst at pos
+// and pushes a job removing the record when the occurrence is undone.
+// The value and end of the occurrence are filled in once chosen.
+func (b *bitState) pushUse(inst *syntax.Inst, occurrence int, pos int) {
+	b.uses = append(b.uses, varUse{inst: inst, occurrence: occurrence, start: pos, end: -1})
+	b.jobs = append(b.jobs, job{undo: undoUse})
+}
+
+// setUseEnd sets the end of the innermost occurrence, keeping boundFp
+// up to date when the occurrence binds a value repeated by ${=name}.
+func (b *bitState) setUseEnd(end int) {
+	u := &b.uses[len(b.uses)-1]
+	if u.inst.Op == syntax.InstStringVar && !u.ref && b.refs[u.inst.Str] {
+		if u.end >= 0 {
+			b.boundFp ^= mix(uint64(u.start)+1, u.end)
+		}
+		if end >= 0 {
+			b.boundFp ^= mix(uint64(u.start)+1, end)
+		}
+	}
+	u.end = end
+}
+
+// bound returns the innermost occurrence of the string variable
+// name on the current path, or nil if name has no value bound yet.
+func (b *bitState) bound(name string) *varUse {
+	for j := len(b.uses) - 1; j >= 0; j-- {
+		u := &b.uses[j]
+		if u.inst.Op == syntax.InstStringVar && !u.ref && u.end >= 0 && u.inst.Str == name {
+			return u
+		}
+	}
+	return nil
+}
+
+// matchBound reports whether the input at pos repeats the text from
+// start to end, and returns the position following the repetition.
+func matchBound(i input, start, end, pos int, fold bool) (int, bool) {
+	for start < end {
+		r1, w1 := i.step(start)
+		r2, w2 := i.step(pos)
+		if r1 != r2 && !(fold && r2 != endOfText && equalFold(r1, r2)) {
+			return 0, false
+		}
+		start += w1
+		pos += w2
+	}
+	return pos, true
+}
+
+// equalFold reports whether r1 and r2 are equal under simple case folding.
+func equalFold(r1, r2 rune) bool {
+	for f := unicode.SimpleFold(r1); f != r1; f = unicode.SimpleFold(f) {
+		if f == r2 {
+			return true
+		}
+	}
+	return false
+}
+
+// A valueEnd is a string variable value found in the input
+// and the position following it.
+type valueEnd struct {
+	n   *node
+	end int
+}
+
+// findValues appends to ends the values of the trie below n that the input
+// spells from pos on, matching case-insensitively if fold is set, and
+// returns the extended slice.
+func findValues(ends []valueEnd, i input, n *node, pos int, fold bool) []valueEnd {
+	r, width := i.step(pos)
+	if r == endOfText {
+		return ends
+	}
+	pos += width
+	f := r
+	for {
+		if next := n.child(f); next != nil {
+			if next.Cnt > 0 {
+				ends = append(ends, valueEnd{next, pos})
+			}
+			ends = findValues(ends, i, next, pos, fold)
+		}
+		if !fold {
+			return ends
+		}
+		if f = unicode.SimpleFold(f); f == r {
+			return ends
+		}
+	}
+}
+
+// regVarCap returns the number of captures to record when re is matched
+// as the value of a reg variable: its groups are reported along with the
+// occurrence, if it has any.
+func (re *Regexp) regVarCap() int {
+	return (1 + re.numSubexp) * 2
+}
+
+// groups returns a copy of the captures of the current match of the reg
+// variable search b, or nil if its Regexp has no groups.
+func groups(b *bitState) []int {
+	if len(b.cap) <= 2 {
+		return nil
+	}
+	return append([]int(nil), b.cap...)
+}
+
 // tryBacktrack runs a backtracking search starting at pos.
-func (re *Regexp) tryBacktrack(b *bitState, i input, pc uint32, pos int) bool {
-	longest := re.longest
+func (re *Regexp) tryBacktrack(b *bitState, i input, pc uint32, pos int, longest bool) bool {
+	b.start = pos
+	if b.visitedStart+b.visitedLen <= b.end && pos-b.visitedStart > b.visitedLen/2 {
+		// No search reaches back before pos; move the window along.
+		b.clearVisited(re.prog, pos)
+	}
+	for {
+		b.push(re, pc, pos, false)
+		matched := re.run(b, i, longest)
+		if !b.overflow || b.parent != nil || b.stopped() {
+			return matched
+		}
+		// Some search was pruned at the end of its window, so the
+		// outcome may be wrong; start over with a larger one.
+		b.unwind(re)
+		b.overflow = false
+		b.widen++
+		b.clearVisited(re.prog, pos)
+	}
+}
 
-	b.push(re, pc, pos, false)
-	return re.run(b, i, longest)
+// unwind drops the jobs left by a search, undoing its changes to the
+// variable state and the captures, and forgets its match.
+func (b *bitState) unwind(re *Regexp) {
+	for len(b.jobs) > 0 {
+		j := b.jobs[len(b.jobs)-1]
+		b.jobs = b.jobs[:len(b.jobs)-1]
+		if j.undo != noUndo {
+			b.undo(&j)
+		} else if j.arg && re.prog.Inst[j.pc].Op == syntax.InstCapture {
+			b.cap[re.prog.Inst[j.pc].Arg] = j.pos
+		}
+	}
+	for i := range b.matchcap {
+		b.matchcap[i] = -1
+	}
+	b.matchUses = b.matchUses[:0]
 }
 
 func (re *Regexp) run(b *bitState, i input, longest bool) bool {
@@ -155,8 +514,8 @@ Loop:
 		// Pop job off the stack.
 		curjob := b.jobs[l]
 		b.jobs = b.jobs[:l]
-		if curjob.f != nil {
-			curjob.f()
+		if curjob.undo != noUndo {
+			b.undo(&curjob)
 			continue
 		}
 		pc := curjob.pc
@@ -176,6 +535,9 @@ Loop:
 			continue
 		}
 	Skip:
+		if b.limit != nil && !b.limit.step() {
+			return false
+		}
 
 		inst := re.prog.Inst[pc]
 
@@ -283,14 +645,14 @@ Loop:
 			goto CheckAndLoop
 
 		case syntax.InstMatch:
-			for _, treeNode := range re.stringVar {
-				if treeNode.count < treeNode.min {
+			for variable, treeNode := range re.vars.stringVar {
+				if min, _ := re.stringVarLimit(variable, treeNode); b.vars.strCount[treeNode] < min {
 					continue Loop
 				}
 			}
 
-			for _, regNode := range re.regVar {
-				if regNode.count < regNode.min {
+			for variable, regNode := range re.vars.regVar {
+				if min, _ := re.regVarLimit(variable, regNode); b.vars.regCount[regNode] < min {
 					continue Loop
 				}
 			}
@@ -309,6 +671,7 @@ Loop:
 			}
 			if old := b.matchcap[1]; old == -1 || (longest && pos > 0 && pos > old) {
 				copy(b.matchcap, b.cap)
+				b.matchUses = append(b.matchUses[:0], b.uses...)
 			}
 
 			// If going for first match, we're done.
@@ -325,37 +688,120 @@ Loop:
 			continue
 		case syntax.InstStringVar:
 			if arg {
+				// The value ending at node has been tried and longer values
+				// are tried next, unless node was reached through a case
+				// folded rune and its own value has not been tried yet.
+				// A chosen node is a value found in the input beforehand
+				// and is tried alone.
 				arg = false
-				node := curjob.aux.(*node)
-				for r, width := i.step(pos); r != endOfText; r, width = i.step(pos) {
-					node = node.Next[r]
-					if node == nil {
-						continue Loop
+				var n *node
+				tried, chosen := false, false
+				switch aux := curjob.aux.(type) {
+				case *node:
+					n, tried = aux, true
+				case *foldedNode:
+					n = aux.unfold()
+				case *chosenNode:
+					n, chosen = aux.unchoose(), true
+				}
+				node := n
+				fold := re.stringVarFoldCase(&re.prog.Inst[pc])
+				reusable := re.vars.stringVar[inst.Str].mode == Reusable
+				for {
+					if !tried && node.Cnt > 0 && reusable {
+						b.setUseEnd(pos)
+						if !chosen {
+							b.jobs = append(b.jobs, job{pc: pc, arg: true, pos: pos, aux: node})
+						}
+						pc = inst.Out
+						goto CheckAndLoop
 					}
-					pos += width
-					if node.Cnt > 0 {
-						node.Cnt--
-						b.jobs = append(b.jobs, job{pc: pc, arg: true, pos: pos, aux: node})
-						b.jobs = append(b.jobs, job{f: func() {
-							node.Cnt++
-						}})
+					if !tried && b.vars.remaining(node) > 0 {
+						b.vars.use(node, 1)
+						b.setUseEnd(pos)
+						b.uses[len(b.uses)-1].node = node
+						if !chosen {
+							b.jobs = append(b.jobs, job{pc: pc, arg: true, pos: pos, aux: node})
+						}
+						b.jobs = append(b.jobs, job{undo: undoValue, aux: node})
 						pc = inst.Out
 						goto CheckAndLoop
 					}
+					if chosen {
+						continue Loop
+					}
+					tried = false
+					r, width := i.step(pos)
+					if r == endOfText {
+						continue Loop
+					}
+					next := node.child(r)
+					if fold {
+						// Try the other runes of the folding orbit later.
+						for f := unicode.SimpleFold(r); f != r; f = unicode.SimpleFold(f) {
+							if n := node.child(f); n != nil {
+								if next == nil {
+									next = n
+								} else {
+									b.jobs = append(b.jobs, job{pc: pc, arg: true, pos: pos + width, aux: (*foldedNode)(n)})
+								}
+							}
+						}
+					}
+					if next == nil {
+						continue Loop
+					}
+					node = next
+					pos += width
 				}
 			} else {
-				treeNode := re.stringVar[inst.Str]
+				treeNode := re.vars.stringVar[inst.Str]
 				if treeNode == nil {
 					panic("string var " + inst.Str + " is unregistered")
 				}
-				if treeNode.count >= treeNode.max {
+				if syntax.Flags(inst.Arg)&syntax.VarRef != 0 {
+					// ${=name} repeats the value bound by the innermost
+					// ${name}; it uses no value of its own.
+					u := b.bound(inst.Str)
+					if u == nil {
+						continue
+					}
+					end, ok := matchBound(i, u.start, u.end, pos, re.stringVarFoldCase(&re.prog.Inst[pc]))
+					if !ok {
+						continue
+					}
+					b.pushUse(&re.prog.Inst[pc], u.occurrence, pos)
+					b.uses[len(b.uses)-1].ref = true
+					b.uses[len(b.uses)-1].end = end
+					pc = inst.Out
+					pos = end
+					goto CheckAndLoop
+				}
+				min, max := re.stringVarLimit(inst.Str, treeNode)
+				if b.vars.strCount[treeNode] >= max {
+					continue
+				}
+				// The uses of an unlimited reusable variable do not
+				// constrain the rest of the match.
+				free := treeNode.mode == Reusable && min == 0 && max == math.MaxInt64
+				b.vars.countStr(treeNode, 1, free)
+				b.jobs = append(b.jobs, job{undo: undoStrCount, arg: free, aux: treeNode})
+				b.pushUse(&re.prog.Inst[pc], b.vars.strCount[treeNode], pos)
+				if treeNode.pref == Shortest {
+					// Walk the trie along the input, trying each value
+					// on the way before going on to longer ones.
+					b.jobs = append(b.jobs, job{pc: pc, arg: true, pos: pos, aux: treeNode.root})
 					continue
 				}
-				treeNode.count++
-				b.jobs = append(b.jobs, job{f: func() {
-					treeNode.count--
-				}})
-				b.jobs = append(b.jobs, job{pc: pc, arg: true, pos: pos, aux: treeNode.root})
+				ends := findValues(nil, i, treeNode.root, pos, re.stringVarFoldCase(&re.prog.Inst[pc]))
+				if treeNode.pref == Longest {
+					sort.SliceStable(ends, func(x, y int) bool { return ends[x].end > ends[y].end })
+				} else {
+					sort.Slice(ends, func(x, y int) bool { return ends[x].n.seq < ends[y].n.seq })
+				}
+				for j := len(ends) - 1; j >= 0; j-- {
+					b.jobs = append(b.jobs, job{pc: pc, arg: true, pos: ends[j].end, aux: (*chosenNode)(ends[j].n)})
+				}
 			}
 			continue
 		case syntax.InstRegVar:
@@ -372,9 +818,12 @@ Loop:
 					bit := node.b
 					end := b.end
 
-					if !regexp.run(bit, i, regexp.longest) {
-						for j := node.maxSearchEnd; j < end; j++ {
-							bit = regexp.backtrackForRegVar(i, j, end, 2, []int{})
+					if !regexp.run(bit, i, false) {
+						if re.vars.regVar[inst.Str].anchored {
+							continue
+						}
+						for j := node.maxSearchEnd; j < end && !b.stopped(); j++ {
+							bit = regexp.backtrackForRegVar(b, i, j, end, false, regexp.regVarCap(), []int{})
 							if bit != nil {
 								node.b = bit
 								goto match
@@ -390,54 +839,59 @@ Loop:
 						node.maxSearchEnd++
 					}
 					b.jobs = append(b.jobs, job{pc: pc, arg: true, pos: pos, aux: node})
+					b.uses[len(b.uses)-1].end = bit.cap[1]
+					b.uses[len(b.uses)-1].caps = groups(bit)
 					pc = inst.Out
 					pos = bit.cap[1]
 					goto CheckAndLoop
 				case *Element:
+					regNode := re.vars.regVar[inst.Str]
 					for ; node != nil; node = node.Next() {
+						reusable := regNode.mode == Reusable
+						if !reusable && b.vars.regUsed[node] {
+							continue
+						}
 						regexp := node.Value.(*Regexp)
 
-						bit := regexp.backtrackForRegVar(i, pos, b.end, 2, []int{})
+						bit := regexp.backtrackForRegVar(b, i, pos, b.end, regNode.anchored, regexp.regVarCap(), []int{})
 						if bit == nil {
 							continue
 						}
 						b.jobs = append(b.jobs, job{pc: pc, arg: true, pos: pos, aux: node.Next()})
 
-						if prev := node.Prev(); prev != nil {
-							b.jobs = append(b.jobs, job{f: func() {
-								prev.InsertElementAfter(node)
-							}})
-						} else if next := node.Next(); next != nil {
-							b.jobs = append(b.jobs, job{f: func() {
-								next.InsertElementBefore(node)
-							}})
-						} else {
-							l := node.list
-							b.jobs = append(b.jobs, job{f: func() {
-								l.root.InsertElementBefore(node)
-							}})
+						if !reusable {
+							b.jobs = append(b.jobs, job{undo: undoRegValue, aux: node})
 						}
 						if bit.cap[0] != bit.cap[1] {
 							b.jobs = append(b.jobs, job{pc: pc, arg: true, pos: pos, aux: &researchReg{re: regexp, b: bit, maxSearchEnd: bit.matchcap[1]}})
 						}
-						node.RemoveSelf()
+						if !reusable {
+							b.vars.useReg(node, true)
+						}
+						b.uses[len(b.uses)-1].elem = node
+						b.uses[len(b.uses)-1].end = bit.matchcap[1]
+						b.uses[len(b.uses)-1].caps = groups(bit)
 						pc = inst.Out
 						pos = bit.matchcap[1]
 						goto CheckAndLoop
 					}
 				}
 			} else {
-				regNode := re.regVar[inst.Str]
+				regNode := re.vars.regVar[inst.Str]
 				if regNode == nil {
 					panic("string var " + inst.Str + " is unregistered")
 				}
-				if regNode.count >= regNode.max {
+				min, max := re.regVarLimit(inst.Str, regNode)
+				if b.vars.regCount[regNode] >= max {
 					continue
 				}
-				regNode.count++
-				b.jobs = append(b.jobs, job{f: func() {
-					regNode.count--
-				}})
+				// As for string variables, the uses of an unlimited
+				// reusable reg variable do not constrain the rest of
+				// the match.
+				free := regNode.mode == Reusable && min == 0 && max == math.MaxInt64
+				b.vars.countReg(regNode, 1, free)
+				b.jobs = append(b.jobs, job{undo: undoRegCount, arg: free, aux: regNode})
+				b.pushUse(&re.prog.Inst[pc], b.vars.regCount[regNode], pos)
 				b.jobs = append(b.jobs, job{pc: pc, arg: true, pos: pos, aux: regNode.l.Front()})
 			}
 			continue
@@ -449,6 +903,25 @@ Loop:
 
 // backtrack runs a backtracking search of prog on the input starting at pos.
 func (re *Regexp) backtrack(ib []byte, is string, pos int, ncap int, dstCap []int) []int {
+	b := re.backtrackState(ib, is, pos, ncap)
+	if b == nil {
+		return nil
+	}
+	dstCap = append(dstCap, b.matchcap...)
+	freeBitState(b)
+	return dstCap
+}
+
+// backtrackState is like backtrack, but returns the state of a
+// successful search, which the caller must release with freeBitState.
+// It returns nil if there is no match.
+func (re *Regexp) backtrackState(ib []byte, is string, pos int, ncap int) *bitState {
+	return re.backtrackStateFrom(nil, ib, is, pos, ncap)
+}
+
+// backtrackStateFrom is like backtrackState, but if c is not nil the
+// values consumed by earlier matches recorded in c are not used.
+func (re *Regexp) backtrackStateFrom(c *consumption, ib []byte, is string, pos int, ncap int) *bitState {
 	startCond := re.cond
 	if startCond == ^syntax.EmptyOp(0) { // impossible
 		return nil
@@ -458,19 +931,22 @@ func (re *Regexp) backtrack(ib []byte, is string, pos int, ncap int, dstCap []in
 		return nil
 	}
 
-	b := newBitState()
+	b := re.newBitState()
+	b.maxDepth = re.maxRegVarDepth()
+	b.limit = re.newLimit()
+	if c != nil {
+		c.seed(b.vars)
+	}
 	i, end := b.inputs.init(nil, ib, is)
 	b.reset(re.prog, end, ncap)
 
-	re.b = b
-
 	// Anchored search must start at the beginning of the input
 	if startCond&syntax.EmptyBeginText != 0 {
 		if len(b.cap) > 0 {
 			b.cap[0] = pos
 		}
-		if !re.tryBacktrack(b, i, uint32(re.prog.Start), pos) {
-			re.freeBitState()
+		if !re.tryBacktrack(b, i, uint32(re.prog.Start), pos, re.longest) {
+			freeBitState(b)
 			return nil
 		}
 	} else {
@@ -487,7 +963,16 @@ func (re *Regexp) backtrack(ib []byte, is string, pos int, ncap int, dstCap []in
 				// Match requires literal prefix; fast search for it.
 				advance := i.index(re, pos)
 				if advance < 0 {
-					re.freeBitState()
+					freeBitState(b)
+					return nil
+				}
+				pos += advance
+			}
+			if re.startVar != nil {
+				// Match starts with a string variable; find its values.
+				advance := re.varIndex(b, i, pos)
+				if advance < 0 {
+					freeBitState(b)
 					return nil
 				}
 				pos += advance
@@ -496,39 +981,77 @@ func (re *Regexp) backtrack(ib []byte, is string, pos int, ncap int, dstCap []in
 			if len(b.cap) > 0 {
 				b.cap[0] = pos
 			}
-			if re.tryBacktrack(b, i, uint32(re.prog.Start), pos) {
+			if re.tryBacktrack(b, i, uint32(re.prog.Start), pos, re.longest) {
 				// Match must be leftmost; done.
 				goto Match
 			}
+			if b.stopped() {
+				break
+			}
 			_, width = i.step(pos)
 		}
-		re.freeBitState()
+		freeBitState(b)
 		return nil
 	}
 
 Match:
-	dstCap = append(dstCap, b.matchcap...)
-	re.freeBitState()
-	return dstCap
+	return b
 }
 
-// backtrack runs a backtracking search of prog on the input starting at pos.
-func (re *Regexp) backtrackForRegVar(i input, pos, end int, ncap int, dstCap []int) *bitState {
+// DefaultRegVarMaxDepth is the maximum nesting of reg variable searches
+// of a Regexp for which SetRegVarMaxDepth has not been called.
+const DefaultRegVarMaxDepth = 64
+
+func (re *Regexp) maxRegVarDepth() int {
+	if re.regVarDepth == 0 {
+		return DefaultRegVarMaxDepth
+	}
+	return re.regVarDepth
+}
+
+// reenters reports whether a search of re starting at pos would repeat
+// b or one of the searches enclosing it without consuming any input,
+// as a Regexp registered on its own reg variable does when the variable
+// comes first. Such a search could only recurse until maxDepth.
+func (b *bitState) reenters(re *Regexp, pos int) bool {
+	for a := b; a != nil; a = a.parent {
+		if a.re == re && a.start == pos {
+			return true
+		}
+	}
+	return false
+}
+
+// backtrackForRegVar runs a backtracking search of prog on the input of the
+// outer match parent starting at pos. The returned state belongs to parent
+// and may be resumed to look for further matches. The search stops at the
+// first match even if re prefers the longest one, so that the outer match
+// can choose among all of them.
+func (re *Regexp) backtrackForRegVar(parent *bitState, i input, pos, end int, anchored bool, ncap int, dstCap []int) *bitState {
 	startCond := re.cond
 	if startCond == ^syntax.EmptyOp(0) { // impossible
 		return nil
 	}
 
-	b := newBitState()
+	if parent.depth >= parent.maxDepth {
+		return nil
+	}
+
+	b := re.newBitState()
+	b.parent = parent
+	b.depth = parent.depth + 1
+	b.maxDepth = parent.maxDepth
+	b.limit = parent.limit
 	b.reset(re.prog, end, ncap)
-	re.b = b
+	parent.nested = append(parent.nested, b)
 
-	// Anchored search must start at the beginning of the input
-	if startCond&syntax.EmptyBeginText != 0 {
+	// Anchored search must start at the beginning of the input,
+	// or at pos for an anchored reg variable.
+	if anchored || startCond&syntax.EmptyBeginText != 0 {
 		if len(b.cap) > 0 {
 			b.cap[0] = pos
 		}
-		if !re.tryBacktrack(b, i, uint32(re.prog.Start), pos) {
+		if parent.reenters(re, pos) || !re.tryBacktrack(b, i, uint32(re.prog.Start), pos, false) {
 			return nil
 		}
 	} else {
@@ -549,14 +1072,25 @@ func (re *Regexp) backtrackForRegVar(i input, pos, end int, ncap int, dstCap []i
 				}
 				pos += advance
 			}
+			if re.startVar != nil {
+				// Match starts with a string variable; find its values.
+				advance := re.varIndex(b, i, pos)
+				if advance < 0 {
+					return nil
+				}
+				pos += advance
+			}
 
 			if len(b.cap) > 0 {
 				b.cap[0] = pos
 			}
-			if re.tryBacktrack(b, i, uint32(re.prog.Start), pos) {
+			if !parent.reenters(re, pos) && re.tryBacktrack(b, i, uint32(re.prog.Start), pos, false) {
 				// Match must be leftmost; done.
 				goto Match
 			}
+			if b.stopped() {
+				return nil
+			}
 			_, width = i.step(pos)
 		}
 		return nil
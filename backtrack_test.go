@@ -0,0 +1,88 @@
+package regPlus
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// BenchmarkVarBacktrack measures the time and allocations of matches
+// that backtrack through the values of variables.
+func BenchmarkVarBacktrack(b *testing.B) {
+	words := MustCompile("^(?:${w} )*${w}$")
+	words.RegisterStringVarByMap("w", map[string]int{"a": 9, "ab": 9, "abc": 9, "b": 9, "bc": 9, "c": 9})
+	wordText := strings.Repeat("abc ab a bc c ", 8) + "b"
+
+	split := MustCompile("^(?:${w})*[bc]$")
+	split.RegisterStringVarByMap("w", map[string]int{"a": 40, "aa": 40, "aaa": 40})
+	splitText := strings.Repeat("a", 40) + "xb"
+
+	shortest := MustCompile("(${w})+x")
+	shortest.RegisterStringVar("w", "ab", "abab", "b")
+	shortest.SetStringVarPreference("w", Shortest)
+	shortestText := strings.Repeat("ab", 16) + "x"
+
+	reg := MustCompile("^@{num}(?:,@{num})*$")
+	reg.RegisterRegVar("num", MustCompile(`\d+`), MustCompile(`0x[0-9a-f]+`))
+	reg.SetRegVarMode("num", Reusable)
+	regText := strings.Repeat("12,0xff,", 8) + "7"
+
+	for _, bm := range []struct {
+		name string
+		re   *Regexp
+		text string
+		want bool
+	}{
+		{"words", words, wordText, true},
+		{"split", split, splitText, false},
+		{"shortest", shortest, shortestText, true},
+		{"regvar", reg, regText, true},
+	} {
+		b.Run(bm.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if bm.re.MatchString(bm.text) != bm.want {
+					b.Fatalf("MatchString(%q) != %v", bm.text, bm.want)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkVarBacktrackLarge compares searches with a variable through
+// inputs too large for a bit vector covering all of them with the same
+// searches by a pattern without variables, which are not backtracked.
+// Each search uses a new Regexp, so that its memory is counted.
+func BenchmarkVarBacktrackLarge(b *testing.B) {
+	newVars := func() *Regexp {
+		re := MustCompile(`[a-z]+=${w};`)
+		re.RegisterStringVar("w", "alpha", "beta", "gamma")
+		re.SetStringVarMode("w", Reusable)
+		return re
+	}
+	newPlain := func() *Regexp {
+		return MustCompile(`[a-z]+=(?:alpha|beta|gamma);`)
+	}
+
+	for _, size := range []int{400 << 10, 1100 << 10} {
+		line := "delta=12, epsilon=345; zeta "
+		text := strings.Repeat(line, size/len(line)) + "x=gamma;"
+		for _, bm := range []struct {
+			name string
+			new  func() *Regexp
+		}{
+			{"vars", newVars},
+			{"plain", newPlain},
+		} {
+			b.Run(bm.name+"/"+strconv.Itoa(size>>10)+"K", func(b *testing.B) {
+				b.ReportAllocs()
+				b.SetBytes(int64(len(text)))
+				for i := 0; i < b.N; i++ {
+					if loc := bm.new().FindStringIndex(text); loc == nil || loc[1] != len(text) {
+						b.Fatalf("FindStringIndex = %v, want a match ending at %d", loc, len(text))
+					}
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,426 @@
+package regPlus
+
+import "math"
+
+// Bindings is an immutable set of string and reg variables together with
+// their limits. Every method returns a new Bindings and leaves the receiver
+// unchanged, so a Bindings may be built once and shared by multiple
+// goroutines. Use Regexp.With to match with it:
+//
+//	b := NewBindings().StringVar("word", "abc", "def").StringVarLimit("word", 1, 2)
+//	re.With(b).FindString(s)
+type Bindings struct {
+	stringVar map[string]*StringTreeNode
+	regVar    map[string]*RegNode
+}
+
+type RegNode struct {
+	l        list
+	min, max int
+	limited  bool // min and max were set explicitly
+	mode     VarMode
+	anchored bool // values must start where the variable occurs
+}
+
+type StringTreeNode struct {
+	root     *node
+	min, max int
+	limited  bool // min and max were set explicitly
+	foldCase bool // match values case-insensitively regardless of flags
+	mode     VarMode
+	pref     StringVarPreference
+
+	// cache holds what is derived from the values for searching. It is
+	// shared by the copies of the struct that share root.
+	cache *valueCache
+}
+
+// A VarMode tells whether the values of a variable are used up by
+// matching them.
+type VarMode uint8
+
+const (
+	// Consuming values match as many times as they were registered
+	// within one match. It is the default.
+	Consuming VarMode = iota
+	// Reusable values match any number of times, making the variable a
+	// plain alternation of its values.
+	Reusable
+)
+
+func (m VarMode) String() string {
+	if m == Reusable {
+		return "Reusable"
+	}
+	return "Consuming"
+}
+
+// A StringVarPreference is the order in which a string variable tries
+// the values found in the input where it occurs.
+type StringVarPreference uint8
+
+const (
+	// Shortest tries shorter values first. It is the default.
+	Shortest StringVarPreference = iota
+	// Longest tries longer values first.
+	Longest
+	// RegistrationOrder tries the values in the order they were
+	// registered, the first registration of a value counting.
+	RegistrationOrder
+)
+
+func (p StringVarPreference) String() string {
+	switch p {
+	case Longest:
+		return "Longest"
+	case RegistrationOrder:
+		return "RegistrationOrder"
+	}
+	return "Shortest"
+}
+
+// NewBindings returns an empty Bindings.
+func NewBindings() *Bindings {
+	return &Bindings{}
+}
+
+// StringVar returns b with strs registered on the string variable.
+func (b *Bindings) StringVar(variable string, strs ...string) *Bindings {
+	nb := b.clone()
+	nb.copyStringTreeNode(variable).root.Insert(strs...)
+	return nb
+}
+
+// StringVarByMap returns b with each string of m registered
+// m[string] times on the string variable.
+func (b *Bindings) StringVarByMap(variable string, m map[string]int) *Bindings {
+	nb := b.clone()
+	treeNode := nb.copyStringTreeNode(variable)
+	for str, count := range m {
+		treeNode.root.InsertWithTimes(str, count)
+	}
+	return nb
+}
+
+// StringVarLimit returns b with the string variable limited to be
+// used between min and max times.
+func (b *Bindings) StringVarLimit(variable string, min, max int) *Bindings {
+	nb := b.clone()
+	if treeNode := nb.stringVar[variable]; treeNode != nil {
+		t := *treeNode
+		nb.stringVar[variable] = &t
+	}
+	nb.setStringVarLimit(variable, min, max)
+	return nb
+}
+
+// StringVarFoldCase returns b with the string variable matching its
+// values case-insensitively or not, as for Regexp.SetStringVarFoldCase.
+func (b *Bindings) StringVarFoldCase(variable string, fold bool) *Bindings {
+	nb := b.clone()
+	if treeNode := nb.stringVar[variable]; treeNode != nil {
+		t := *treeNode
+		nb.stringVar[variable] = &t
+	}
+	nb.setStringVarFoldCase(variable, fold)
+	return nb
+}
+
+// StringVarMode returns b with the string variable in the given mode,
+// as for Regexp.SetStringVarMode.
+func (b *Bindings) StringVarMode(variable string, mode VarMode) *Bindings {
+	nb := b.clone()
+	if treeNode := nb.stringVar[variable]; treeNode != nil {
+		t := *treeNode
+		nb.stringVar[variable] = &t
+	}
+	nb.setStringVarMode(variable, mode)
+	return nb
+}
+
+// StringVarPreference returns b with the string variable trying its values
+// in the order of pref, as for Regexp.SetStringVarPreference.
+func (b *Bindings) StringVarPreference(variable string, pref StringVarPreference) *Bindings {
+	nb := b.clone()
+	if treeNode := nb.stringVar[variable]; treeNode != nil {
+		t := *treeNode
+		nb.stringVar[variable] = &t
+	}
+	nb.setStringVarPreference(variable, pref)
+	return nb
+}
+
+// RegVar returns b with regs registered on the reg variable.
+func (b *Bindings) RegVar(variable string, regs ...*Regexp) *Bindings {
+	nb := b.clone()
+	regNode := nb.copyRegNode(variable)
+	for _, reg := range regs {
+		regNode.l.PushBack(reg)
+	}
+	return nb
+}
+
+// RegVarMode returns b with the reg variable in the given mode,
+// as for Regexp.SetRegVarMode.
+func (b *Bindings) RegVarMode(variable string, mode VarMode) *Bindings {
+	nb := b.clone()
+	if regNode := nb.regVar[variable]; regNode != nil {
+		nb.regVar[variable] = regNode.copy()
+	}
+	nb.setRegVarMode(variable, mode)
+	return nb
+}
+
+// RegVarAnchored returns b with the reg variable anchored or not,
+// as for Regexp.SetRegVarAnchored.
+func (b *Bindings) RegVarAnchored(variable string, anchored bool) *Bindings {
+	nb := b.clone()
+	if regNode := nb.regVar[variable]; regNode != nil {
+		nb.regVar[variable] = regNode.copy()
+	}
+	nb.setRegVarAnchored(variable, anchored)
+	return nb
+}
+
+// RegVarLimit returns b with the reg variable limited to be
+// used between min and max times.
+func (b *Bindings) RegVarLimit(variable string, min, max int) *Bindings {
+	nb := b.clone()
+	if regNode := nb.regVar[variable]; regNode != nil {
+		nb.regVar[variable] = regNode.copy()
+	}
+	nb.setRegVarLimit(variable, min, max)
+	return nb
+}
+
+// UnregisterVar returns b without the string variable and the reg
+// variable named variable, as for Regexp.UnregisterVar.
+func (b *Bindings) UnregisterVar(variable string) *Bindings {
+	nb := b.clone()
+	nb.unregisterVar(variable)
+	return nb
+}
+
+// RemoveStringVarValues returns b with strs no longer registered on the
+// string variable, as for Regexp.RemoveStringVarValues.
+func (b *Bindings) RemoveStringVarValues(variable string, strs ...string) *Bindings {
+	nb := b.clone()
+	if treeNode := nb.stringVar[variable]; treeNode != nil {
+		nb.stringVar[variable] = treeNode.copy()
+	}
+	nb.removeStringVarValues(variable, strs...)
+	return nb
+}
+
+// ReplaceStringVar returns b with strs as the only values of the string
+// variable, as for Regexp.ReplaceStringVar.
+func (b *Bindings) ReplaceStringVar(variable string, strs ...string) *Bindings {
+	nb := b.clone()
+	if treeNode := nb.stringVar[variable]; treeNode != nil {
+		t := *treeNode
+		nb.stringVar[variable] = &t
+	}
+	nb.replaceStringVar(variable, strs...)
+	return nb
+}
+
+// ClearRegVar returns b without any Regexp registered on the reg
+// variable, as for Regexp.ClearRegVar.
+func (b *Bindings) ClearRegVar(variable string) *Bindings {
+	nb := b.clone()
+	if regNode := nb.regVar[variable]; regNode != nil {
+		r := *regNode
+		nb.regVar[variable] = &r
+	}
+	nb.clearRegVar(variable)
+	return nb
+}
+
+// StringVarMemory returns the approximate number of bytes taken by the
+// values registered on the string variable, or 0 if it is unregistered.
+func (b *Bindings) StringVarMemory(variable string) int {
+	treeNode := b.stringVar[variable]
+	if treeNode == nil {
+		return 0
+	}
+	return treeNode.root.memory()
+}
+
+// clone returns a copy of b sharing its variables.
+func (b *Bindings) clone() *Bindings {
+	nb := &Bindings{
+		stringVar: map[string]*StringTreeNode{},
+		regVar:    map[string]*RegNode{},
+	}
+	if b != nil {
+		for k, v := range b.stringVar {
+			nb.stringVar[k] = v
+		}
+		for k, v := range b.regVar {
+			nb.regVar[k] = v
+		}
+	}
+	return nb
+}
+
+// deepCopy returns a copy of b that can be modified in place.
+func (b *Bindings) deepCopy() *Bindings {
+	nb := b.clone()
+	for k, v := range nb.stringVar {
+		nb.stringVar[k] = v.copy()
+	}
+	for k, v := range nb.regVar {
+		nb.regVar[k] = v.copy()
+	}
+	return nb
+}
+
+// copyStringTreeNode is like getStringTreeNode, but replaces an
+// existing variable with a copy first.
+func (b *Bindings) copyStringTreeNode(variable string) *StringTreeNode {
+	if treeNode := b.stringVar[variable]; treeNode != nil {
+		b.stringVar[variable] = treeNode.copy()
+	}
+	return b.getStringTreeNode(variable)
+}
+
+// copyRegNode is like getRegNode, but replaces an existing variable
+// with a copy first.
+func (b *Bindings) copyRegNode(variable string) *RegNode {
+	if regNode := b.regVar[variable]; regNode != nil {
+		b.regVar[variable] = regNode.copy()
+	}
+	return b.getRegNode(variable)
+}
+
+func (b *Bindings) getStringTreeNode(variable string) *StringTreeNode {
+	if b.stringVar == nil {
+		b.stringVar = map[string]*StringTreeNode{}
+	}
+	treeNode := b.stringVar[variable]
+	if treeNode == nil {
+		treeNode = &StringTreeNode{root: &node{}, max: math.MaxInt64, cache: &valueCache{}}
+		b.stringVar[variable] = treeNode
+	}
+	return treeNode
+}
+
+func (b *Bindings) setStringVarLimit(variable string, min, max int) {
+	treeNode := b.stringVar[variable]
+	if treeNode == nil {
+		panic("string var " + variable + " is unregistered")
+	}
+	if min > max {
+		panic("Invalid limit: min can't bigger than max")
+	}
+	treeNode.min = min
+	treeNode.max = max
+	treeNode.limited = true
+}
+
+func (b *Bindings) setStringVarFoldCase(variable string, fold bool) {
+	treeNode := b.stringVar[variable]
+	if treeNode == nil {
+		panic("string var " + variable + " is unregistered")
+	}
+	treeNode.foldCase = fold
+}
+
+func (b *Bindings) setStringVarMode(variable string, mode VarMode) {
+	treeNode := b.stringVar[variable]
+	if treeNode == nil {
+		panic("string var " + variable + " is unregistered")
+	}
+	treeNode.mode = mode
+}
+
+func (b *Bindings) setStringVarPreference(variable string, pref StringVarPreference) {
+	treeNode := b.stringVar[variable]
+	if treeNode == nil {
+		panic("string var " + variable + " is unregistered")
+	}
+	treeNode.pref = pref
+}
+
+func (b *Bindings) getRegNode(variable string) *RegNode {
+	if b.regVar == nil {
+		b.regVar = map[string]*RegNode{}
+	}
+	regNode := b.regVar[variable]
+	if regNode == nil {
+		regNode = &RegNode{l: list{}, max: math.MaxInt64}
+		b.regVar[variable] = regNode
+	}
+	return regNode
+}
+
+func (b *Bindings) setRegVarLimit(variable string, min, max int) {
+	regNode := b.regVar[variable]
+	if regNode == nil {
+		panic("reg var " + variable + " is unregistered")
+	}
+	if min > max {
+		panic("Invalid limit: min can't bigger than max")
+	}
+	regNode.min = min
+	regNode.max = max
+	regNode.limited = true
+}
+
+func (b *Bindings) setRegVarMode(variable string, mode VarMode) {
+	regNode := b.regVar[variable]
+	if regNode == nil {
+		panic("reg var " + variable + " is unregistered")
+	}
+	regNode.mode = mode
+}
+
+func (b *Bindings) setRegVarAnchored(variable string, anchored bool) {
+	regNode := b.regVar[variable]
+	if regNode == nil {
+		panic("reg var " + variable + " is unregistered")
+	}
+	regNode.anchored = anchored
+}
+
+func (b *Bindings) unregisterVar(variable string) {
+	delete(b.stringVar, variable)
+	delete(b.regVar, variable)
+}
+
+func (b *Bindings) removeStringVarValues(variable string, strs ...string) {
+	treeNode := b.stringVar[variable]
+	if treeNode == nil {
+		panic("string var " + variable + " is unregistered")
+	}
+	for _, str := range strs {
+		treeNode.root.Remove(str)
+	}
+}
+
+func (b *Bindings) replaceStringVar(variable string, strs ...string) {
+	treeNode := b.getStringTreeNode(variable)
+	treeNode.root = &node{}
+	treeNode.root.Insert(strs...)
+	treeNode.cache = &valueCache{}
+}
+
+func (b *Bindings) clearRegVar(variable string) {
+	regNode := b.regVar[variable]
+	if regNode == nil {
+		panic("reg var " + variable + " is unregistered")
+	}
+	regNode.l = list{}
+}
+
+func (t *StringTreeNode) copy() *StringTreeNode {
+	return &StringTreeNode{root: t.root.clone(), min: t.min, max: t.max, limited: t.limited, foldCase: t.foldCase, mode: t.mode, pref: t.pref, cache: &valueCache{}}
+}
+
+func (r *RegNode) copy() *RegNode {
+	n := &RegNode{l: list{}, min: r.min, max: r.max, limited: r.limited, mode: r.mode, anchored: r.anchored}
+	for e := r.l.Front(); e != nil; e = e.Next() {
+		n.l.PushBack(e.Value)
+	}
+	return n
+}
@@ -0,0 +1,89 @@
+package regPlus
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestRegexp_With(t *testing.T) {
+	mustCompile := MustCompile("a\\(${word}\\)b\\(${word}\\)cd")
+	tenant1 := NewBindings().StringVar("word", "abc", "def")
+	tenant2 := NewBindings().StringVarByMap("word", map[string]int{"xyz": 2})
+
+	cases := []struct {
+		name   string
+		b      *Bindings
+		text   string
+		expect string
+	}{
+		{
+			"No.1", tenant1, "a(abc)b(def)cd e", "a(abc)b(def)cd",
+		},
+		{
+			"No.2", tenant1, "a(xyz)b(xyz)cd e", "",
+		},
+		{
+			"No.3", tenant2, "a(xyz)b(xyz)cd e", "a(xyz)b(xyz)cd",
+		},
+		{
+			"No.4", tenant2, "a(abc)b(def)cd e", "",
+		},
+		{
+			"No.5", tenant1.StringVarLimit("word", 0, 1), "a(abc)b(def)cd e", "",
+		},
+		{
+			"No.6", tenant1.StringVar("word", "abc"), "a(abc)b(abc)cd e", "a(abc)b(abc)cd",
+		},
+	}
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, mustCompile.With(tt.b).FindString(tt.text), tt.expect)
+		})
+	}
+
+	// Deriving new Bindings and Regexps leaves the originals untouched.
+	assert.Equal(t, mustCompile.With(tenant1).FindString("a(abc)b(abc)cd e"), "")
+	assert.Nil(t, mustCompile.vars.stringVar["word"])
+
+	bound := mustCompile.With(tenant1)
+	bound.RegisterStringVar("word", "abc")
+	assert.Equal(t, bound.FindString("a(abc)b(abc)cd e"), "a(abc)b(abc)cd")
+	assert.Equal(t, mustCompile.With(tenant1).FindString("a(abc)b(abc)cd e"), "")
+}
+
+func TestBindings_RegVar(t *testing.T) {
+	mustCompile := MustCompile("a(@{var})2b(@{var})")
+	b := NewBindings().RegVar("var", MustCompile("\\d+"), MustCompile("[a-z]*"))
+
+	assert.Equal(t, mustCompile.With(b).FindStringSubmatch("a302bacR"), []string{"a302bac", "30", "ac"})
+	assert.Nil(t, mustCompile.With(b.RegVarLimit("var", 3, 3)).FindStringSubmatch("a302bacR"))
+	assert.Equal(t, mustCompile.With(b).FindStringSubmatch("a302bacR"), []string{"a302bac", "30", "ac"})
+}
+
+func TestBindings_RemoveValues(t *testing.T) {
+	mustCompile := MustCompile("a${word}@{num}")
+	b := NewBindings().StringVar("word", "bc", "de").RegVar("num", MustCompile(`\d`))
+
+	removed := b.RemoveStringVarValues("word", "bc")
+	assert.Equal(t, mustCompile.With(removed).FindString("abc1 ade2"), "ade2")
+	assert.Equal(t, mustCompile.With(b).FindString("abc1 ade2"), "abc1")
+
+	replaced := b.ReplaceStringVar("word", "xy")
+	assert.Equal(t, mustCompile.With(replaced).FindString("abc1 axy2"), "axy2")
+	assert.Equal(t, mustCompile.With(b).FindString("abc1 axy2"), "abc1")
+
+	assert.Equal(t, mustCompile.With(b.ClearRegVar("num")).FindString("abc1"), "")
+	assert.Equal(t, mustCompile.With(b.ClearRegVar("num").RegVar("num", MustCompile(`\d`))).FindString("abc1"), "abc1")
+	assert.Panics(t, func() { mustCompile.With(b.UnregisterVar("word")).FindString("abc1") })
+	assert.Equal(t, mustCompile.With(b).FindString("abc1"), "abc1")
+}
+
+func TestBindings_StringVarMemory(t *testing.T) {
+	b := NewBindings().StringVar("word", "abc", "abd")
+	assert.Equal(t, b.StringVarMemory("word") > 0, true)
+	assert.Equal(t, b.StringVarMemory("missing"), 0)
+
+	mustCompile := MustCompile("${word}")
+	mustCompile.RegisterStringVar("word", "abc", "abd")
+	assert.Equal(t, mustCompile.StringVarMemory("word"), b.StringVarMemory("word"))
+}
@@ -0,0 +1,129 @@
+package regPlus
+
+import (
+	"bufio"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// A DictFormat is the layout of the lines read by RegisterStringVarFromReader.
+type DictFormat uint8
+
+const (
+	// ValuePerLine lines hold one value each, registered once per line.
+	ValuePerLine DictFormat = iota
+	// ValueTabCount lines hold a value, a tab and the number of times the
+	// value is registered, as for RegisterStringVarByMap.
+	ValueTabCount
+)
+
+// DictOptions tell RegisterStringVarFromReader how to read its lines.
+// The zero value reads one value per line, without comments or trimming.
+// Empty lines are always skipped.
+type DictOptions struct {
+	Format DictFormat
+
+	// Comment, if not empty, starts the lines to skip.
+	Comment string
+
+	// TrimSpace removes the white space around values and counts, and
+	// before Comment.
+	TrimSpace bool
+}
+
+// A DictError reports a line that RegisterStringVarFromReader cannot read.
+type DictError struct {
+	Line int // line number, starting at 1
+	Msg  string
+}
+
+func (e *DictError) Error() string {
+	return "regexp: dictionary line " + strconv.Itoa(e.Line) + ": " + e.Msg
+}
+
+// RegisterStringVarFromReader registers the values read from r on the string
+// variable, one line at a time, in the layout given by opts; nil opts are the
+// zero DictOptions. It returns a *DictError for a malformed line, or the
+// error of r, in which case no value is registered.
+func (re *Regexp) RegisterStringVarFromReader(variable string, r io.Reader, opts *DictOptions) error {
+	values, err := readDict(r, opts)
+	if err != nil {
+		return err
+	}
+	treeNode := re.ownedVars().getStringTreeNode(variable)
+	for _, v := range values {
+		treeNode.root.InsertWithTimes(v.str, v.count)
+	}
+	return nil
+}
+
+// StringVarFromReader returns b with the values read from r registered on
+// the string variable, as for Regexp.RegisterStringVarFromReader.
+// It returns b unchanged along with any error.
+func (b *Bindings) StringVarFromReader(variable string, r io.Reader, opts *DictOptions) (*Bindings, error) {
+	values, err := readDict(r, opts)
+	if err != nil {
+		return b, err
+	}
+	nb := b.clone()
+	treeNode := nb.copyStringTreeNode(variable)
+	for _, v := range values {
+		treeNode.root.InsertWithTimes(v.str, v.count)
+	}
+	return nb, nil
+}
+
+type dictValue struct {
+	str   string
+	count int
+}
+
+// readDict reads the values of a dictionary from r.
+func readDict(r io.Reader, opts *DictOptions) ([]dictValue, error) {
+	if opts == nil {
+		opts = &DictOptions{}
+	}
+	var values []dictValue
+	br := bufio.NewReader(r)
+	for n := 1; ; n++ {
+		line, err := br.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		if line == "" && err == io.EOF {
+			return values, nil
+		}
+		line = strings.TrimSuffix(line, "\n")
+		line = strings.TrimSuffix(line, "\r")
+		if opts.TrimSpace {
+			line = strings.TrimSpace(line)
+		}
+		switch {
+		case line == "":
+		case opts.Comment != "" && strings.HasPrefix(line, opts.Comment):
+		case opts.Format == ValueTabCount:
+			tab := strings.LastIndexByte(line, '\t')
+			if tab < 0 {
+				return nil, &DictError{Line: n, Msg: "missing tab before the count"}
+			}
+			str, num := line[:tab], line[tab+1:]
+			if opts.TrimSpace {
+				str, num = strings.TrimSpace(str), strings.TrimSpace(num)
+			}
+			count, cerr := strconv.Atoi(num)
+			if cerr != nil || count <= 0 {
+				return nil, &DictError{Line: n, Msg: "invalid count " + strconv.Quote(num)}
+			}
+			if str == "" {
+				return nil, &DictError{Line: n, Msg: "empty value"}
+			}
+			values = append(values, dictValue{str, count})
+		default:
+			values = append(values, dictValue{line, 1})
+		}
+		if err == io.EOF {
+			return values, nil
+		}
+	}
+}
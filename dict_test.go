@@ -0,0 +1,72 @@
+package regPlus
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func TestRegexp_RegisterStringVarFromReader(t *testing.T) {
+	cases := []struct {
+		name   string
+		text   string
+		opts   *DictOptions
+		expect map[string]int
+		err    string
+	}{
+		{
+			"No.1", "abc\ndef\r\n\nabc", nil, map[string]int{"abc": 2, "def": 1}, "",
+		},
+		{
+			"No.2", "# names\n  abc \n\t# tab\ndef\n", &DictOptions{Comment: "#", TrimSpace: true}, map[string]int{"abc": 1, "def": 1}, "",
+		},
+		{
+			"No.3", " abc \n", nil, map[string]int{" abc ": 1}, "",
+		},
+		{
+			"No.4", "abc\t2\na b\t 3 \n// skipped\n", &DictOptions{Format: ValueTabCount, Comment: "//", TrimSpace: true}, map[string]int{"abc": 2, "a b": 3}, "",
+		},
+		{
+			"No.5", "abc\t2\ndef\n", &DictOptions{Format: ValueTabCount}, nil, "regexp: dictionary line 2: missing tab before the count",
+		},
+		{
+			"No.6", "abc\t2\n\ndef\tx\n", &DictOptions{Format: ValueTabCount}, nil, `regexp: dictionary line 3: invalid count "x"`,
+		},
+		{
+			"No.7", "\t2\n", &DictOptions{Format: ValueTabCount}, nil, "regexp: dictionary line 1: empty value",
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			mustCompile := MustCompile("${word}")
+			err := mustCompile.RegisterStringVarFromReader("word", strings.NewReader(tt.text), tt.opts)
+			if tt.err != "" {
+				assert.EqualError(t, err, tt.err)
+				assert.Nil(t, mustCompile.vars.stringVar["word"])
+				return
+			}
+			assert.Nil(t, err)
+			assert.Equal(t, mustCompile.NewSession().Remaining("word"), tt.expect)
+		})
+	}
+}
+
+func TestRegexp_RegisterStringVarFromReader_ReadError(t *testing.T) {
+	mustCompile := MustCompile("${word}")
+	failure := errors.New("read failure")
+	r := io.MultiReader(strings.NewReader("abc\n"), iotest.ErrReader(failure))
+	assert.Equal(t, mustCompile.RegisterStringVarFromReader("word", r, nil), failure)
+	assert.Nil(t, mustCompile.vars.stringVar["word"])
+}
+
+func TestBindings_StringVarFromReader(t *testing.T) {
+	b := NewBindings().StringVar("word", "abc")
+	nb, err := b.StringVarFromReader("word", strings.NewReader("def\n"), nil)
+	assert.Nil(t, err)
+	assert.Equal(t, MustCompile("${word}").With(nb).FindString("def"), "def")
+	assert.Equal(t, MustCompile("${word}").With(b).FindString("def"), "")
+}
@@ -528,6 +528,21 @@ func (re *Regexp) doExecute(r io.RuneReader, b []byte, s string, pos int, ncap i
 		return nil
 	}
 
+	if r == nil && re.hasVar {
+		if re.cannotMatch(b, s, pos) {
+			return nil
+		}
+		// Variables carry consumption state that only the
+		// backtracker tracks, so use it at any input size.
+		return re.backtrack(b, s, pos, ncap, dstCap)
+	}
+	if r != nil && re.hasVar {
+		// A reader cannot be stepped backwards; buffer it for the backtracker.
+		// A search exceeding the reader window or the match budget ends
+		// without a match; only the ...Err methods report it.
+		a, _ := re.backtrackReader(r, ncap, dstCap)
+		return a
+	}
 	if re.onepass != nil {
 		return re.doOnePass(r, b, s, pos, ncap, dstCap)
 	}
@@ -0,0 +1,151 @@
+package regPlus
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrBudgetExceeded is returned by the ...Err and ...Context methods when
+// the search for a match of a pattern with variables takes more steps than
+// allowed by SetMatchBudget.
+var ErrBudgetExceeded = errors.New("regexp: match budget exceeded")
+
+// SetMatchBudget bounds the number of steps the backtracker may take to
+// search for a match of a pattern with variables, counting the searches
+// for the values of its reg variables. A search that needs more stops
+// without a match: the ...Err and ...Context methods return
+// ErrBudgetExceeded and the other methods report no match, or no further
+// match for the FindAll methods, which search once for each match. A value
+// of n <= 0, the default, removes the bound.
+func (re *Regexp) SetMatchBudget(n int) {
+	if n < 0 {
+		n = 0
+	}
+	re.budget = n
+}
+
+// A matchLimit stops a search that exceeds the budget of its Regexp or
+// whose context is done. It is shared by the states of the reg variable
+// searches nested in the search.
+type matchLimit struct {
+	budget int
+	steps  int
+	ctx    context.Context
+	err    error  // why the search was stopped
+	stop   *error // receives the first such error, if not nil
+}
+
+// newLimit returns the limit of a search for re, or nil if re has no
+// variables or neither a budget nor a context.
+func (re *Regexp) newLimit() *matchLimit {
+	if !re.hasVar || re.budget == 0 && re.ctx == nil {
+		return nil
+	}
+	return &matchLimit{budget: re.budget, ctx: re.ctx, stop: re.stop}
+}
+
+// halt stops the search with err.
+func (l *matchLimit) halt(err error) {
+	l.err = err
+	if l.stop != nil && *l.stop == nil {
+		*l.stop = err
+	}
+}
+
+// step counts a step of the search and reports whether it may go on.
+// The context is polled every 1024 steps.
+func (l *matchLimit) step() bool {
+	if l.err != nil {
+		return false
+	}
+	l.steps++
+	if l.budget > 0 && l.steps > l.budget {
+		l.halt(ErrBudgetExceeded)
+		return false
+	}
+	if l.ctx != nil && l.steps&1023 == 0 {
+		if err := l.ctx.Err(); err != nil {
+			l.halt(err)
+			return false
+		}
+	}
+	return true
+}
+
+// stopped reports whether the search of b was stopped by its limit.
+func (b *bitState) stopped() bool {
+	return b.limit != nil && b.limit.err != nil
+}
+
+// matchErr checks re with Validate, then calls match with re, or with a
+// copy of re recording why its searches stop if they can be stopped.
+// It returns the error of Validate or the first error stopping a search.
+func (re *Regexp) matchErr(match func(m *Regexp)) error {
+	if err := re.Validate(); err != nil {
+		return err
+	}
+	if !re.hasVar || re.budget == 0 && re.ctx == nil {
+		match(re)
+		return nil
+	}
+	var stop error
+	m := *re
+	m.stop = &stop
+	m.ownVars = false
+	match(&m)
+	return stop
+}
+
+// withContext returns a shallow copy of re whose searches stop when ctx
+// is done.
+func (re *Regexp) withContext(ctx context.Context) *Regexp {
+	re2 := *re
+	re2.ctx = ctx
+	re2.ownVars = false
+	return &re2
+}
+
+// MatchStringContext is like MatchStringErr, but stops with the error of
+// ctx once it is done.
+func (re *Regexp) MatchStringContext(ctx context.Context, s string) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	return re.withContext(ctx).MatchStringErr(s)
+}
+
+// FindStringContext is like FindStringErr, but stops with the error of
+// ctx once it is done.
+func (re *Regexp) FindStringContext(ctx context.Context, s string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	return re.withContext(ctx).FindStringErr(s)
+}
+
+// FindStringIndexContext is like FindStringIndexErr, but stops with the
+// error of ctx once it is done.
+func (re *Regexp) FindStringIndexContext(ctx context.Context, s string) ([]int, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return re.withContext(ctx).FindStringIndexErr(s)
+}
+
+// FindStringSubmatchContext is like FindStringSubmatchErr, but stops with
+// the error of ctx once it is done.
+func (re *Regexp) FindStringSubmatchContext(ctx context.Context, s string) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return re.withContext(ctx).FindStringSubmatchErr(s)
+}
+
+// FindAllStringContext is like FindAllStringErr, but stops with the error
+// of ctx once it is done.
+func (re *Regexp) FindAllStringContext(ctx context.Context, s string, n int) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return re.withContext(ctx).FindAllStringErr(s, n)
+}
@@ -0,0 +1,117 @@
+package regPlus
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+	"time"
+)
+
+// backtrackingRegexp returns a pattern whose failing searches try many
+// ways of splitting a run of a's into values.
+func backtrackingRegexp() *Regexp {
+	mustCompile := MustCompile("^(?:${w})*[bc]$")
+	mustCompile.RegisterStringVarByMap("w", map[string]int{"a": 60, "aa": 60, "aaa": 60})
+	return mustCompile
+}
+
+func TestRegexp_SetMatchBudget(t *testing.T) {
+	text := strings.Repeat("a", 60) + "xb"
+	mustCompile := backtrackingRegexp()
+
+	matched, err := mustCompile.MatchStringErr(text)
+	assert.Equal(t, matched, false)
+	assert.Nil(t, err)
+
+	mustCompile.SetMatchBudget(1000)
+	matched, err = mustCompile.MatchStringErr(text)
+	assert.Equal(t, matched, false)
+	assert.Equal(t, err, ErrBudgetExceeded)
+	all, err := mustCompile.FindAllStringErr(text, -1)
+	assert.Equal(t, all, []string(nil))
+	assert.Equal(t, err, ErrBudgetExceeded)
+	_, err = mustCompile.FindReaderIndexErr(strings.NewReader(text))
+	assert.Equal(t, err, ErrBudgetExceeded)
+
+	// The methods without errors report no match.
+	assert.Equal(t, mustCompile.MatchString(text), false)
+	assert.Equal(t, mustCompile.FindAllString(text, -1), []string(nil))
+	assert.Equal(t, mustCompile.FindStringVarMatches(text), []VarMatch(nil))
+	assert.Equal(t, mustCompile.MatchReader(strings.NewReader(text)), false)
+
+	// Matches found within the budget are unaffected.
+	loc, err := mustCompile.FindStringIndexErr("aaab")
+	assert.Equal(t, loc, []int{0, 4})
+	assert.Nil(t, err)
+
+	small := MustCompile("(?:${w})*x")
+	small.RegisterStringVar("w", "a", "aa")
+	small.SetMatchBudget(10)
+	assert.Equal(t, small.FindString("aaaaaaaax"), "")
+	assert.Equal(t, small.Split("aaaaaaaax", -1), []string{"aaaaaaaax"})
+	_, err = small.FindStringErr("aaaaaaaax")
+	assert.Equal(t, err, ErrBudgetExceeded)
+
+	mustCompile.SetMatchBudget(0)
+	matched, err = mustCompile.MatchStringErr(text)
+	assert.Equal(t, matched, false)
+	assert.Nil(t, err)
+}
+
+func TestRegexp_MatchBudgetRegVar(t *testing.T) {
+	mustCompile := MustCompile("^@{run}[bc]$")
+	mustCompile.RegisterRegVar("run", backtrackingRegexp())
+	mustCompile.SetMatchBudget(1000)
+	_, err := mustCompile.FindStringErr(strings.Repeat("a", 60) + "xbb")
+	assert.Equal(t, err, ErrBudgetExceeded)
+}
+
+// cancelAfter is a context whose Err reports context.Canceled from its
+// nth call on.
+type cancelAfter struct {
+	context.Context
+	n int
+}
+
+func (c *cancelAfter) Err() error {
+	if c.n--; c.n <= 0 {
+		return context.Canceled
+	}
+	return nil
+}
+
+func TestRegexp_FindStringContext(t *testing.T) {
+	text := strings.Repeat("a", 60) + "xb"
+	mustCompile := backtrackingRegexp()
+
+	s, err := mustCompile.FindStringContext(context.Background(), "aab")
+	assert.Equal(t, s, "aab")
+	assert.Nil(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = mustCompile.FindStringContext(ctx, "aab")
+	assert.Equal(t, err, context.Canceled)
+
+	// The context is cancelled in the middle of the search.
+	ctx = &cancelAfter{Context: context.Background(), n: 2}
+	matched, err := mustCompile.MatchStringContext(ctx, text)
+	assert.Equal(t, matched, false)
+	assert.Equal(t, err, context.Canceled)
+
+	ctx, cancel = context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	all, err := mustCompile.FindAllStringContext(ctx, "ab aab", -1)
+	assert.Equal(t, all, []string(nil))
+	assert.Nil(t, err)
+	sub, err := mustCompile.FindStringSubmatchContext(ctx, "aaac")
+	assert.Equal(t, sub, []string{"aaac"})
+	assert.Nil(t, err)
+	loc, err := mustCompile.FindStringIndexContext(ctx, "aaac")
+	assert.Equal(t, loc, []int{0, 4})
+	assert.Nil(t, err)
+
+	// The Regexp itself keeps no context.
+	assert.Nil(t, mustCompile.ctx)
+}
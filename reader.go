@@ -0,0 +1,259 @@
+package regPlus
+
+import (
+	"errors"
+	"github.com/koleter/regPlus/syntax"
+	"io"
+	"unicode/utf8"
+)
+
+// DefaultReaderWindow is the reader window of a Regexp for which
+// SetReaderWindow has not been called.
+const DefaultReaderWindow = 1 << 20
+
+// ErrReaderWindow is returned by the ...Err reader methods when matching a
+// pattern with variables against an io.RuneReader needs more input than
+// fits in the reader window. The other reader methods report no match.
+var ErrReaderWindow = errors.New("regexp: match needs more input than the reader window")
+
+// SetReaderWindow sets the maximum number of bytes buffered when matching
+// a pattern with variables against an io.RuneReader. A match attempt at
+// a position of the input must be decided within n bytes, counting the
+// rune before it; text before the attempt is dropped from the buffer.
+// A value of n <= 0 restores DefaultReaderWindow.
+// Patterns without variables read their input one rune at a time and
+// are not limited.
+func (re *Regexp) SetReaderWindow(n int) {
+	if n < 0 {
+		n = 0
+	}
+	re.readerWindow = n
+}
+
+// inputWindow scans the buffered part of a RuneReader.
+// It records when a search looks past the end of the buffer, which
+// makes its outcome depend on input that has not been read yet.
+type inputWindow struct {
+	inputBytes
+	r     io.RuneReader
+	base  int   // offset of str[0] in the input
+	eof   bool  // str holds the rest of the input
+	err   error // error other than io.EOF returned by r
+	short bool  // the search looked past the end of str
+}
+
+func (i *inputWindow) step(pos int) (rune, int) {
+	if pos >= len(i.str) && !i.eof {
+		i.short = true
+	}
+	return i.inputBytes.step(pos)
+}
+
+func (i *inputWindow) index(re *Regexp, pos int) int {
+	n := i.inputBytes.index(re, pos)
+	if n < 0 && !i.eof {
+		i.short = true
+	}
+	return n
+}
+
+func (i *inputWindow) context(pos int) lazyFlag {
+	if pos >= len(i.str) && !i.eof {
+		i.short = true
+	}
+	return i.inputBytes.context(pos)
+}
+
+// fill reads from r until str holds at least n bytes or the input ends.
+func (i *inputWindow) fill(n int) {
+	for len(i.str) < n && !i.eof {
+		r, size, err := i.r.ReadRune()
+		if err != nil {
+			if err != io.EOF {
+				i.err = err
+			}
+			i.eof = true
+			break
+		}
+		if r == utf8.RuneError && size == 1 {
+			// Keep the byte offsets of the input: an invalid byte
+			// decodes again as a single RuneError.
+			i.str = append(i.str, 0xff)
+		} else {
+			i.str = utf8.AppendRune(i.str, r)
+		}
+	}
+}
+
+// discard drops the first n bytes of str.
+func (i *inputWindow) discard(n int) {
+	i.str = append(i.str[:0], i.str[n:]...)
+	i.base += n
+}
+
+// backtrackReader is like backtrack for the input read from r. It buffers
+// the input around the current match attempt and reads more of it whenever
+// the outcome of the attempt depends on the unread part. It returns
+// ErrReaderWindow if the attempt cannot be decided within the reader window,
+// and ErrBudgetExceeded or the error of the context of re if the search is
+// stopped.
+// An error reading r ends the input, as for patterns without variables,
+// and is returned along with the result.
+func (re *Regexp) backtrackReader(r io.RuneReader, ncap int, dstCap []int) ([]int, error) {
+	startCond := re.cond
+	if startCond == ^syntax.EmptyOp(0) { // impossible
+		return nil, nil
+	}
+	anchored := startCond&syntax.EmptyBeginText != 0
+	window := re.readerWindow
+	if window == 0 {
+		window = DefaultReaderWindow
+	}
+	chunk := window
+	if chunk > 4096 {
+		chunk = 4096
+	}
+
+	i := &inputWindow{r: r}
+	i.fill(chunk)
+	b := re.newBitState()
+	b.maxDepth = re.maxRegVarDepth()
+	b.limit = re.newLimit()
+	defer freeBitState(b)
+	pos := 0
+	for {
+		b.reset(re.prog, len(i.str), ncap)
+		i.short = false
+		matched := false
+		for {
+			if re.startVar != nil && !anchored {
+				// Skip to the next value of the string variable the
+				// match starts with; without one, the attempt fails
+				// unless more input holds one.
+				advance := re.varIndex(b, i, pos)
+				if advance < 0 {
+					break
+				}
+				pos += advance
+			}
+			if len(b.cap) > 0 {
+				b.cap[0] = pos
+			}
+			if re.tryBacktrack(b, i, uint32(re.prog.Start), pos, re.longest) {
+				matched = true
+				if re.longest && len(b.matchcap) > 1 && b.matchcap[1] == len(i.str) && !i.eof {
+					// A longer match may continue past the buffer.
+					i.short = true
+				}
+				break
+			}
+			if b.stopped() {
+				return nil, b.limit.err
+			}
+			if i.short || anchored {
+				break
+			}
+			_, width := i.step(pos)
+			if width == 0 {
+				break
+			}
+			pos += width
+		}
+		if !i.short {
+			if !matched {
+				return nil, i.err
+			}
+			for _, c := range b.matchcap {
+				if c >= 0 {
+					c += i.base
+				}
+				dstCap = append(dstCap, c)
+			}
+			return dstCap, i.err
+		}
+
+		// The attempt at pos needs more input. The attempts before it
+		// have failed, so keep only the rune before pos for context.
+		if keep := pos; keep > 0 {
+			keep--
+			for keep > 0 && !utf8.RuneStart(i.str[keep]) {
+				keep--
+			}
+			i.discard(keep)
+			pos -= keep
+		}
+		if len(i.str) >= window {
+			return nil, ErrReaderWindow
+		}
+		// The attempt may have matched, leaving its values in use;
+		// retry it with all of them available.
+		b.clearVars()
+		n := 2 * len(i.str)
+		if n < chunk {
+			n = chunk
+		}
+		if n > window {
+			n = window
+		}
+		i.fill(n)
+	}
+}
+
+// doExecuteReader is like doExecute for a pattern with variables matched
+// against r, but returns the error that ended the search and a nil result
+// along with any error.
+func (re *Regexp) doExecuteReader(r io.RuneReader, ncap int) ([]int, error) {
+	a, err := re.backtrackReader(r, ncap, arrayNoInts[:0:0])
+	if err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// MatchReaderErr is like MatchReader, but returns the error of Validate
+// instead of matching if the variables of re are not usable, and
+// ErrReaderWindow, ErrBudgetExceeded or the error of r instead of reporting
+// no match.
+func (re *Regexp) MatchReaderErr(r io.RuneReader) (bool, error) {
+	if err := re.Validate(); err != nil {
+		return false, err
+	}
+	if !re.hasVar {
+		return re.MatchReader(r), nil
+	}
+	a, err := re.doExecuteReader(r, 0)
+	return a != nil, err
+}
+
+// FindReaderIndexErr is like FindReaderIndex, but returns the error of
+// Validate instead of matching if the variables of re are not usable, and
+// ErrReaderWindow, ErrBudgetExceeded or the error of r instead of reporting
+// no match.
+func (re *Regexp) FindReaderIndexErr(r io.RuneReader) ([]int, error) {
+	if err := re.Validate(); err != nil {
+		return nil, err
+	}
+	if !re.hasVar {
+		return re.FindReaderIndex(r), nil
+	}
+	a, err := re.doExecuteReader(r, 2)
+	if a == nil {
+		return nil, err
+	}
+	return a[0:2], nil
+}
+
+// FindReaderSubmatchIndexErr is like FindReaderSubmatchIndex, but returns
+// the error of Validate instead of matching if the variables of re are not
+// usable, and ErrReaderWindow, ErrBudgetExceeded or the error of r instead
+// of reporting no match.
+func (re *Regexp) FindReaderSubmatchIndexErr(r io.RuneReader) ([]int, error) {
+	if err := re.Validate(); err != nil {
+		return nil, err
+	}
+	if !re.hasVar {
+		return re.FindReaderSubmatchIndex(r), nil
+	}
+	a, err := re.doExecuteReader(r, re.prog.NumCap)
+	return re.pad(a), err
+}
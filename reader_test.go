@@ -0,0 +1,121 @@
+package regPlus
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestRegexp_FindReaderIndex_Var(t *testing.T) {
+	cases := []struct {
+		name   string
+		reg    string
+		window int
+		text   string
+		expect []int
+	}{
+		{
+			"No.1", "a${word}b${word}", 0, "xxaabcbdef", []int{2, 10},
+		},
+		{
+			"No.2", "a${word}b${word}", 0, "xxaabcbabc", nil,
+		},
+		{
+			// The match lies far beyond the first buffer.
+			"No.3", "a${word}b${word}", 0, strings.Repeat("x", 10000) + "aabcbdefx", []int{10000, 10008},
+		},
+		{
+			// Text before the attempt is dropped, so a small window suffices.
+			"No.4", "a${word}b${word}", 16, strings.Repeat("ab", 5000) + "aabcbdefx", []int{10000, 10008},
+		},
+		{
+			"No.5", "\\ba${word}b${word}$", 16, strings.Repeat("x", 5000) + " aabcbdef", []int{5001, 5009},
+		},
+		{
+			"No.6", "^${word}", 16, strings.Repeat("x", 5000) + "abc", nil,
+		},
+		{
+			"No.7", "x@{var}x", 0, "ab x123x", []int{3, 8},
+		},
+	}
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			mustCompile := MustCompile(tt.reg)
+			mustCompile.RegisterStringVar("word", "abc", "def")
+			mustCompile.RegisterRegVar("var", MustCompile("\\d+"))
+			mustCompile.SetReaderWindow(tt.window)
+			assert.Equal(t, mustCompile.FindReaderIndex(strings.NewReader(tt.text)), tt.expect)
+			assert.Equal(t, mustCompile.MatchReader(strings.NewReader(tt.text)), tt.expect != nil)
+		})
+	}
+
+	submatch := MustCompile("(${word})-(${word})")
+	submatch.RegisterStringVar("word", "ab", "cd")
+	assert.Equal(t, submatch.FindReaderSubmatchIndex(strings.NewReader("x cd-ab")), []int{2, 7, 2, 4, 5, 7})
+}
+
+func TestRegexp_ReaderWindow(t *testing.T) {
+	mustCompile := MustCompile("a${word}.*z")
+	mustCompile.RegisterStringVar("word", "abc")
+	mustCompile.SetReaderWindow(64)
+	text := "aabc" + strings.Repeat("x", 100) + "z"
+
+	_, err := mustCompile.FindReaderIndexErr(strings.NewReader(text))
+	assert.Equal(t, err, ErrReaderWindow)
+	// The methods without errors report no match.
+	assert.Equal(t, mustCompile.MatchReader(strings.NewReader(text)), false)
+	assert.Equal(t, mustCompile.FindReaderIndex(strings.NewReader(text)), []int(nil))
+
+	mustCompile.SetReaderWindow(128)
+	loc, err := mustCompile.FindReaderIndexErr(strings.NewReader(text))
+	assert.Equal(t, loc, []int{0, 105})
+	assert.Nil(t, err)
+}
+
+type failingReader struct {
+	r io.RuneReader
+}
+
+var errRead = errors.New("read failed")
+
+func (f failingReader) ReadRune() (rune, int, error) {
+	r, size, err := f.r.ReadRune()
+	if err == io.EOF {
+		err = errRead
+	}
+	return r, size, err
+}
+
+func TestRegexp_FindReaderIndexErr(t *testing.T) {
+	mustCompile := MustCompile("a${word}")
+	_, err := mustCompile.FindReaderIndexErr(strings.NewReader("aabc"))
+	assert.Equal(t, err, &VarError{Name: "word", Kind: StringVarKind, Msg: "is unregistered"})
+
+	mustCompile.RegisterStringVar("word", "abc")
+	matched, err := mustCompile.MatchReaderErr(failingReader{strings.NewReader("xaabc")})
+	assert.Equal(t, matched, false)
+	assert.Equal(t, err, errRead)
+	// The methods without errors treat it as the end of the input.
+	assert.Equal(t, mustCompile.FindReaderIndex(failingReader{strings.NewReader("xaabc")}), []int{1, 5})
+}
+
+func TestRegexp_ReaderRetryAfterMatch(t *testing.T) {
+	// The first attempt matches, using a value, but reads to the end of the
+	// first chunk; the retry with more input must find the value again.
+	text := "ab12z" + strings.Repeat("q", 5000)
+	mustCompile := MustCompile("\\w+${w}z")
+	mustCompile.RegisterStringVar("w", "12")
+	assert.Equal(t, mustCompile.FindStringIndex(text), []int{0, 5})
+	assert.Equal(t, mustCompile.FindReaderIndex(strings.NewReader(text)), []int{0, 5})
+	loc, err := mustCompile.FindReaderIndexErr(strings.NewReader(text))
+	assert.Equal(t, loc, []int{0, 5})
+	assert.Nil(t, err)
+
+	regVar := MustCompile("(\\w+)@{n}z")
+	regVar.RegisterRegVar("n", MustCompile("\\d+"))
+	regVar.SetRegVarAnchored("n", true)
+	assert.Equal(t, regVar.FindStringSubmatchIndex(text), []int{0, 5, 0, 3})
+	assert.Equal(t, regVar.FindReaderSubmatchIndex(strings.NewReader(text)), []int{0, 5, 0, 3})
+}
@@ -69,6 +69,7 @@ package regPlus
 
 import (
 	"bytes"
+	"context"
 	"github.com/koleter/regPlus/syntax"
 	"io"
 	"math"
@@ -82,6 +83,8 @@ import (
 // Regexp is the representation of a compiled regular expression.
 // A Regexp is safe for concurrent use by multiple goroutines,
 // except for configuration methods, such as Longest.
+// Registering variables and setting their limits are configuration
+// methods; matching with registered variables is safe for concurrent use.
 type Regexp struct {
 	expr           string       // as passed to Compile
 	prog           *syntax.Prog // compiled program
@@ -98,95 +101,202 @@ type Regexp struct {
 	prefixComplete bool           // prefix is the entire regexp
 	cond           syntax.EmptyOp // empty-width conditions required at start of match
 	minInputLen    int            // minimum length of the input in bytes
+	hasVar         bool           // program contains string or reg variables
 
 	// This field can be modified by the Longest method,
 	// but it is otherwise read-only.
 	longest bool // whether regexp prefers leftmost-longest match
 
-	stringVar map[string]*StringTreeNode
+	// limits holds the limits written on variables in the pattern,
+	// such as ${word:1,3}; limits set on the variables override them.
+	limits map[varKey]varLimit
 
-	// these field is used for regVar
-	regVar map[string]*RegNode
-	b      *bitState
-}
+	// refs holds the string variables referenced as ${=name}.
+	refs map[string]bool
+
+	// varInfo describes the variables of the pattern, for Vars.
+	varInfo []VarInfo
+
+	// readerWindow bounds the input buffered for io.RuneReader matching
+	// of patterns with variables; 0 means DefaultReaderWindow.
+	readerWindow int
+
+	// regVarDepth bounds the nesting of reg variable searches;
+	// 0 means DefaultRegVarMaxDepth.
+	regVarDepth int
+
+	// startVar is the string variable instruction that every match
+	// starts with, if any; its values locate the candidate matches.
+	startVar *syntax.Inst
+
+	// varTree is the simplified syntax of a pattern with variables, for
+	// varMinInputLen, and required the longest literal all its matches
+	// contain.
+	varTree       *syntax.Regexp
+	required      string
+	requiredBytes []byte
+
+	// consumeAcross carries the consumption of variable values over
+	// from each match of the FindAll methods to the next.
+	consumeAcross bool
+
+	// bitStates pools the backtracker states released by searches of
+	// the program; it is shared by the copies of the Regexp.
+	bitStates *sync.Pool
+
+	// budget bounds the steps of a search for a match with variables
+	// (0 means no bound), and ctx, if not nil, stops it once done. stop,
+	// set on the copies made by the ...Err methods, receives the error
+	// that stopped a search.
+	budget int
+	ctx    context.Context
+	stop   *error
 
-type RegNode struct {
-	l               list
-	min, max, count int
+	// vars holds the variables registered on re. It is shared, and so
+	// must not be modified in place, unless ownVars is set (see With).
+	vars    *Bindings
+	ownVars bool
 }
 
 func (re *Regexp) RegisterRegVar(variable string, regs ...*Regexp) {
-	regNode := re.getRegNode(variable)
+	regNode := re.ownedVars().getRegNode(variable)
 	for _, reg := range regs {
 		regNode.l.PushBack(reg)
 	}
 }
 
 func (re *Regexp) SetRegVarLimit(variable string, min, max int) {
-	regNode := re.regVar[variable]
-	if regNode == nil {
-		panic("reg var " + variable + " is unregistered")
-	}
-	if min > max {
-		panic("Invalid limit: min can't bigger than max")
-	}
-	regNode.min = min
-	regNode.max = max
+	re.ownedVars().setRegVarLimit(variable, min, max)
 }
 
-func (re *Regexp) getRegNode(variable string) *RegNode {
-	if re.regVar == nil {
-		re.regVar = map[string]*RegNode{}
-	}
-	regNode := re.regVar[variable]
-	if regNode == nil {
-		regNode = &RegNode{l: list{}, max: math.MaxInt64}
-		re.regVar[variable] = regNode
-	}
-	return regNode
+// SetRegVarMode sets whether each Regexp registered on the reg variable
+// can be used once per match, the default, or any number of times.
+// A Regexp registered on one of its own reg variables usually needs
+// Reusable mode to match the same structure more than once at each level.
+func (re *Regexp) SetRegVarMode(variable string, mode VarMode) {
+	re.ownedVars().setRegVarMode(variable, mode)
 }
 
-type StringTreeNode struct {
-	root            *node
-	min, max, count int
+// SetRegVarAnchored sets whether the values of the reg variable must
+// match starting exactly where the variable occurs in the pattern. By
+// default a value is searched for from that position on, so a@{var} with
+// \d+ registered matches "ax1"; anchored, it matches "a1" but not "ax1".
+func (re *Regexp) SetRegVarAnchored(variable string, anchored bool) {
+	re.ownedVars().setRegVarAnchored(variable, anchored)
+}
+
+// SetRegVarMaxDepth sets how deeply the searches for reg variable values
+// may nest when re is matched, which bounds the recursion of a Regexp
+// registered, directly or not, on its own reg variables. A search that
+// would go deeper fails. A value of n <= 0 restores DefaultRegVarMaxDepth.
+func (re *Regexp) SetRegVarMaxDepth(n int) {
+	if n < 0 {
+		n = 0
+	}
+	re.regVarDepth = n
 }
 
 func (re *Regexp) RegisterStringVar(variable string, strs ...string) {
-	treeNode := re.getStringTreeNode(variable)
+	treeNode := re.ownedVars().getStringTreeNode(variable)
 	treeNode.root.Insert(strs...)
 }
 
 func (re *Regexp) SetStringVarLimit(variable string, min, max int) {
-	treeNode := re.stringVar[variable]
-	if treeNode == nil {
-		panic("string var " + variable + " is unregistered")
-	}
-	if min > max {
-		panic("Invalid limit: min can't bigger than max")
-	}
-	treeNode.min = min
-	treeNode.max = max
+	re.ownedVars().setStringVarLimit(variable, min, max)
 }
 
-func (re *Regexp) getStringTreeNode(variable string) *StringTreeNode {
-	if re.stringVar == nil {
-		re.stringVar = map[string]*StringTreeNode{}
-	}
-	treeNode := re.stringVar[variable]
-	if treeNode == nil {
-		treeNode = &StringTreeNode{root: &node{}, max: math.MaxInt64}
-		re.stringVar[variable] = treeNode
-	}
-	return treeNode
+// SetStringVarFoldCase sets whether the string variable matches its values
+// case-insensitively even where the pattern is case-sensitive. Where the
+// pattern is case-insensitive, as in (?i)${word}, values always match using
+// Unicode simple case folding.
+func (re *Regexp) SetStringVarFoldCase(variable string, fold bool) {
+	re.ownedVars().setStringVarFoldCase(variable, fold)
+}
+
+// SetStringVarMode sets whether the values of the string variable are
+// consumed by matching them. In Reusable mode ${word}+ matches any sequence
+// of registered values, each value as often as needed; the counts given to
+// RegisterStringVarByMap are then ignored.
+func (re *Regexp) SetStringVarMode(variable string, mode VarMode) {
+	re.ownedVars().setStringVarMode(variable, mode)
+}
+
+// SetStringVarPreference sets the order in which the string variable tries
+// the registered values found in the input. The first value that lets the
+// rest of the pattern match is kept, so the match found is still the
+// leftmost-first one for that order. Values registered together by
+// RegisterStringVarByMap have no defined registration order.
+func (re *Regexp) SetStringVarPreference(variable string, pref StringVarPreference) {
+	re.ownedVars().setStringVarPreference(variable, pref)
+}
+
+// UnregisterVar removes the string variable and the reg variable named
+// variable from re, together with their values and settings. Matching a
+// pattern that uses a removed variable panics as if it was never registered.
+func (re *Regexp) UnregisterVar(variable string) {
+	re.ownedVars().unregisterVar(variable)
+}
+
+// RemoveStringVarValues removes every registration of strs from the string
+// variable, whatever the number of times they were registered. The other
+// values and the settings of the variable are kept.
+func (re *Regexp) RemoveStringVarValues(variable string, strs ...string) {
+	re.ownedVars().removeStringVarValues(variable, strs...)
+}
+
+// ReplaceStringVar makes strs the only values of the string variable,
+// registering it if needed. The settings of the variable are kept.
+func (re *Regexp) ReplaceStringVar(variable string, strs ...string) {
+	re.ownedVars().replaceStringVar(variable, strs...)
+}
+
+// ClearRegVar removes every Regexp registered on the reg variable, keeping
+// its settings. The variable then matches nothing until more are registered.
+func (re *Regexp) ClearRegVar(variable string) {
+	re.ownedVars().clearRegVar(variable)
+}
+
+// StringVarMemory returns the approximate number of bytes taken by the
+// values registered on the string variable, or 0 if it is unregistered.
+func (re *Regexp) StringVarMemory(variable string) int {
+	return re.vars.StringVarMemory(variable)
 }
 
 func (re *Regexp) RegisterStringVarByMap(variable string, m map[string]int) {
-	treeNode := re.getStringTreeNode(variable)
+	treeNode := re.ownedVars().getStringTreeNode(variable)
 	for str, count := range m {
 		treeNode.root.InsertWithTimes(str, count)
 	}
 }
 
+// With returns a copy of re that matches using the variables of b
+// instead of the ones registered on re. Neither re nor b is modified,
+// so a single compiled Regexp can be matched against different
+// dictionaries, even by multiple goroutines:
+//
+//	re.With(b).FindString(s)
+//
+// Registering variables on the returned Regexp does not affect b.
+func (re *Regexp) With(b *Bindings) *Regexp {
+	if b == nil {
+		b = NewBindings()
+	}
+	re2 := *re
+	re2.vars = b
+	re2.ownVars = false
+	return &re2
+}
+
+// ownedVars returns the variables of re, first copying them
+// if they are shared with a Bindings.
+func (re *Regexp) ownedVars() *Bindings {
+	if !re.ownVars {
+		re.vars = re.vars.deepCopy()
+		re.ownVars = true
+	}
+	return re.vars
+}
+
 // String returns the source text used to compile the regular expression.
 func (re *Regexp) String() string {
 	return re.expr
@@ -194,6 +304,10 @@ func (re *Regexp) String() string {
 
 // Copy returns a new Regexp object copied from re.
 // Calling Longest on one copy does not affect another.
+// The variables registered on re are copied too, together with the
+// Regexps registered on its reg variables, so registering or configuring
+// variables on one copy does not affect another. A Regexp registered on
+// its own reg variables, directly or not, is copied once.
 //
 // Deprecated: In earlier releases, when using a Regexp in multiple goroutines,
 // giving each goroutine its own copy helped to avoid lock contention.
@@ -201,7 +315,24 @@ func (re *Regexp) String() string {
 // Copy may still be appropriate if the reason for its use is to make
 // two copies with different Longest settings.
 func (re *Regexp) Copy() *Regexp {
+	return re.copy(map[*Regexp]*Regexp{})
+}
+
+// copy is Copy for a Regexp registered on a reg variable; copies holds
+// the copies made so far, so that cycles of registrations are kept.
+func (re *Regexp) copy(copies map[*Regexp]*Regexp) *Regexp {
+	if re2, ok := copies[re]; ok {
+		return re2
+	}
 	re2 := *re
+	copies[re] = &re2
+	re2.vars = re.vars.deepCopy()
+	re2.ownVars = true
+	for _, regNode := range re2.vars.regVar {
+		for e := regNode.l.Front(); e != nil; e = e.Next() {
+			e.Value = e.Value.(*Regexp).copy(copies)
+		}
+	}
 	return &re2
 }
 
@@ -257,6 +388,11 @@ func compile(expr string, mode syntax.Flags, longest bool) (*Regexp, error) {
 	if err != nil {
 		return nil, err
 	}
+	limits := map[varKey]varLimit{}
+	if err := varLimits(re, limits); err != nil {
+		return nil, err
+	}
+	varInfo := varInfos(re)
 	maxCap := re.MaxCap()
 	capNames := re.CapNames()
 
@@ -272,13 +408,27 @@ func compile(expr string, mode syntax.Flags, longest bool) (*Regexp, error) {
 	regexp := &Regexp{
 		expr:        expr,
 		prog:        prog,
-		onepass:     compileOnePass(prog),
 		numSubexp:   maxCap,
 		subexpNames: capNames,
 		cond:        prog.StartCond(),
 		longest:     longest,
 		matchcap:    matchcap,
 		minInputLen: minInputLen(re),
+		hasVar:      hasVar(prog),
+		refs:        varRefs(prog),
+		startVar:    startVar(prog),
+		varInfo:     varInfo,
+		limits:      limits,
+		vars:        NewBindings(),
+		ownVars:     true,
+		bitStates:   new(sync.Pool),
+	}
+	if regexp.hasVar {
+		regexp.varTree = re
+		regexp.required = requiredLiteral(re)
+		regexp.requiredBytes = []byte(regexp.required)
+	} else {
+		regexp.onepass = compileOnePass(prog)
 	}
 	if regexp.onepass == nil {
 		regexp.prefix, regexp.prefixComplete = prog.Prefix()
@@ -354,9 +504,20 @@ func (re *Regexp) put(m *machine) {
 
 // minInputLen walks the regexp to find the minimum length of any matchable input
 func minInputLen(re *syntax.Regexp) int {
+	return minLen(re, nil)
+}
+
+// minLen is like minInputLen, but takes the minimum length of the input
+// matched by a variable from varLen; without varLen it is 0.
+func minLen(re *syntax.Regexp, varLen func(v *syntax.Regexp) int) int {
 	switch re.Op {
 	default:
 		return 0
+	case syntax.OpStringVar, syntax.OpRegVar:
+		if varLen == nil {
+			return 0
+		}
+		return varLen(re)
 	case syntax.OpAnyChar, syntax.OpAnyCharNotNL, syntax.OpCharClass:
 		return 1
 	case syntax.OpLiteral:
@@ -370,20 +531,20 @@ func minInputLen(re *syntax.Regexp) int {
 		}
 		return l
 	case syntax.OpCapture, syntax.OpPlus:
-		return minInputLen(re.Sub[0])
+		return minLen(re.Sub[0], varLen)
 	case syntax.OpRepeat:
-		return re.Min * minInputLen(re.Sub[0])
+		return re.Min * minLen(re.Sub[0], varLen)
 	case syntax.OpConcat:
 		l := 0
 		for _, sub := range re.Sub {
-			l += minInputLen(sub)
+			l += minLen(sub, varLen)
 		}
 		return l
 	case syntax.OpAlternate:
-		l := minInputLen(re.Sub[0])
+		l := minLen(re.Sub[0], varLen)
 		var lnext int
 		for _, sub := range re.Sub[1:] {
-			lnext = minInputLen(sub)
+			lnext = minLen(sub, varLen)
 			if lnext < l {
 				l = lnext
 			}
@@ -392,6 +553,176 @@ func minInputLen(re *syntax.Regexp) int {
 	}
 }
 
+// varMinInputLen returns the minimum length of an input matched by re,
+// which has variables, given the values registered on them.
+func (re *Regexp) varMinInputLen() int {
+	return minLen(re.varTree, func(v *syntax.Regexp) int {
+		if v.Op == syntax.OpStringVar {
+			if t := re.vars.stringVar[v.Var]; t != nil {
+				return t.minLen()
+			}
+			return 0
+		}
+		r := re.vars.regVar[v.Var]
+		if r == nil {
+			return 0
+		}
+		min := -1
+		for e := r.l.Front(); e != nil && e != &r.l.root; e = e.Next() {
+			if l := e.Value.(*Regexp).minInputLen; min < 0 || l < min {
+				min = l
+			}
+		}
+		if min < 0 {
+			return 0
+		}
+		return min
+	})
+}
+
+// requiredLiteral returns the longest case-sensitive literal that every
+// match of re contains, or "" if there is none.
+func requiredLiteral(re *syntax.Regexp) string {
+	switch re.Op {
+	case syntax.OpLiteral:
+		if re.Flags&syntax.FoldCase != 0 {
+			return ""
+		}
+		lit, _ := literalPrefix(re)
+		return lit
+	case syntax.OpCapture, syntax.OpPlus:
+		return requiredLiteral(re.Sub[0])
+	case syntax.OpRepeat:
+		if re.Min > 0 {
+			return requiredLiteral(re.Sub[0])
+		}
+	case syntax.OpConcat:
+		// Adjacent literals, as in the simplified form of x{2},
+		// join into one.
+		lit, run := "", ""
+		for _, sub := range re.Sub {
+			l := requiredLiteral(sub)
+			if sub.Op == syntax.OpLiteral && l != "" {
+				run += l
+				l = run
+			} else {
+				run = ""
+			}
+			if len(l) > len(lit) {
+				lit = l
+			}
+			if _, complete := literalPrefix(sub); !complete {
+				run = ""
+			}
+		}
+		return lit
+	}
+	return ""
+}
+
+// literalPrefix returns the runes of the literal re before the first
+// utf8.RuneError, which also matches an invalid byte of the input, and
+// whether that is the whole literal. It returns "", false if re is not
+// a literal.
+func literalPrefix(re *syntax.Regexp) (string, bool) {
+	if re.Op != syntax.OpLiteral {
+		return "", false
+	}
+	for i, r := range re.Rune {
+		if r == utf8.RuneError {
+			return string(re.Rune[:i]), false
+		}
+	}
+	return string(re.Rune), true
+}
+
+// cannotMatch reports whether re, which has variables, has no match in the
+// input b or s from pos on because what is left of the input is shorter
+// than any match or lacks a literal that every match contains.
+func (re *Regexp) cannotMatch(b []byte, s string, pos int) bool {
+	if len(b)+len(s)-pos < re.varMinInputLen() {
+		return true
+	}
+	if re.required == "" {
+		return false
+	}
+	if b != nil {
+		return !bytes.Contains(b[pos:], re.requiredBytes)
+	}
+	return !strings.Contains(s[pos:], re.required)
+}
+
+// A varKey identifies a variable of a pattern: string variables and
+// reg variables of the same name are distinct.
+type varKey struct {
+	op   syntax.Op
+	name string
+}
+
+// A varLimit bounds how many times a variable may be used in a match.
+type varLimit struct {
+	min, max int
+}
+
+// varLimits walks the regexp to collect the limits written on variables
+// into limits. All limited occurrences of a variable must agree.
+func varLimits(re *syntax.Regexp, limits map[varKey]varLimit) error {
+	switch re.Op {
+	case syntax.OpStringVar, syntax.OpRegVar:
+		if re.Min == 0 && re.Max == -1 {
+			return nil
+		}
+		l := varLimit{min: re.Min, max: re.Max}
+		if l.max < 0 {
+			l.max = math.MaxInt64
+		}
+		k := varKey{op: re.Op, name: re.Var}
+		if old, ok := limits[k]; ok && old != l {
+			return &syntax.Error{Code: syntax.ErrInvalidStringVarBounds, Expr: re.String()}
+		}
+		limits[k] = l
+	}
+	for _, sub := range re.Sub {
+		if err := varLimits(sub, limits); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// stringVarLimit returns the limits on the uses of the string variable t:
+// those set by SetStringVarLimit if any, or else those in the pattern.
+func (re *Regexp) stringVarLimit(variable string, t *StringTreeNode) (min, max int) {
+	if !t.limited {
+		if l, ok := re.limits[varKey{syntax.OpStringVar, variable}]; ok {
+			return l.min, l.max
+		}
+	}
+	return t.min, t.max
+}
+
+// stringVarFoldCase reports whether the string variable of inst matches
+// its values case-insensitively, because of the pattern flags or because
+// it was set with SetStringVarFoldCase.
+func (re *Regexp) stringVarFoldCase(inst *syntax.Inst) bool {
+	if syntax.Flags(inst.Arg)&syntax.FoldCase != 0 {
+		return true
+	}
+	t := re.vars.stringVar[inst.Str]
+	return t != nil && t.foldCase
+}
+
+// regVarLimit returns the limits on the uses of the reg variable r:
+// those set by SetRegVarLimit if any, or else those in the pattern.
+func (re *Regexp) regVarLimit(variable string, r *RegNode) (min, max int) {
+	if !r.limited {
+		if l, ok := re.limits[varKey{syntax.OpRegVar, variable}]; ok {
+			return l.min, l.max
+		}
+	}
+	return r.min, r.max
+}
+
 // MustCompile is like Compile but panics if the expression cannot be parsed.
 // It simplifies safe initialization of global variables holding compiled regular
 // expressions.
@@ -855,6 +1186,25 @@ func (re *Regexp) pad(a []int) []int {
 // with the location of successive matches in the input text.
 // The input text is b if non-nil, otherwise s.
 func (re *Regexp) allMatches(s string, b []byte, n int, deliver func([]int)) {
+	re.allVarMatches(s, b, n, false, func(match []int, _ []VarMatch) {
+		deliver(match)
+	})
+}
+
+// allVarMatches is like allMatches, but if withVars is set it also
+// delivers the variable occurrences of each match.
+func (re *Regexp) allVarMatches(s string, b []byte, n int, withVars bool, deliver func([]int, []VarMatch)) {
+	var c *consumption
+	if re.consumeAcross && re.hasVar {
+		c = newConsumption()
+	}
+	re.allVarMatchesFrom(c, s, b, n, withVars, deliver)
+}
+
+// allVarMatchesFrom is like allVarMatches, but if c is not nil the values
+// consumed by each delivered match are recorded in c and not used by
+// later ones.
+func (re *Regexp) allVarMatchesFrom(c *consumption, s string, b []byte, n int, withVars bool, deliver func([]int, []VarMatch)) {
 	var end int
 	if b == nil {
 		end = len(s)
@@ -863,7 +1213,14 @@ func (re *Regexp) allMatches(s string, b []byte, n int, deliver func([]int)) {
 	}
 
 	for pos, i, prevMatchEnd := 0, 0, -1; i < n && pos <= end; {
-		matches := re.doExecute(nil, b, s, pos, re.prog.NumCap, nil)
+		var matches []int
+		var vars []VarMatch
+		var uses []varUse
+		if withVars || c != nil {
+			matches, vars, uses = re.doExecuteVars(c, b, s, pos, re.prog.NumCap)
+		} else {
+			matches = re.doExecute(nil, b, s, pos, re.prog.NumCap, nil)
+		}
 		if len(matches) == 0 {
 			break
 		}
@@ -894,7 +1251,11 @@ func (re *Regexp) allMatches(s string, b []byte, n int, deliver func([]int)) {
 		prevMatchEnd = matches[1]
 
 		if accept {
-			deliver(re.pad(matches))
+			// A rejected empty match uses up nothing.
+			if c != nil {
+				c.record(re, uses)
+			}
+			deliver(re.pad(matches), vars)
 			i++
 		}
 	}
@@ -1362,18 +1723,3 @@ func (re *Regexp) Split(s string, n int) []string {
 
 	return strings
 }
-
-func (re *Regexp) reset() {
-	for _, node := range re.stringVar {
-		node.count = 0
-	}
-}
-
-func (re *Regexp) finalFree() {
-	for _, reg := range re.regVar {
-		for node := reg.l.Front(); node != nil; node = node.Next() {
-			regexp := node.Value.(*Regexp)
-			regexp.freeBitState()
-		}
-	}
-}
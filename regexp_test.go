@@ -2,7 +2,12 @@ package regPlus
 
 import (
 	"github.com/stretchr/testify/assert"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestRegexp_RegisterStringVar(t *testing.T) {
@@ -197,6 +202,40 @@ func TestRegexp_RegisterRegVarWithLimit(t *testing.T) {
 	}
 }
 
+func TestRegexp_RegisterRegVarAnchored(t *testing.T) {
+	cases := []struct {
+		name     string
+		anchored bool
+		text     string
+		expect   string
+	}{
+		{
+			"No.1", true, "a302bacR", "a302bac",
+		},
+		{
+			"No.2", true, "a502q302bacR", "",
+		},
+		{
+			"No.3", false, "xa5q a302bacR", "a5q a302bac",
+		},
+		{
+			"No.4", true, "xa5q a302bacR", "a302bac",
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			mustCompile := MustCompile("a@{var}2b@{var}")
+			mustCompile.RegisterRegVar("var", MustCompile("\\d+"), MustCompile("[a-z]*"))
+			mustCompile.SetRegVarAnchored("var", tt.anchored)
+			assert.Equal(t, mustCompile.FindString(tt.text), tt.expect)
+
+			bindings := NewBindings().RegVar("var", MustCompile("\\d+"), MustCompile("[a-z]*")).RegVarAnchored("var", tt.anchored)
+			assert.Equal(t, MustCompile("a@{var}2b@{var}").With(bindings).FindString(tt.text), tt.expect)
+		})
+	}
+}
+
 // Taking a SQL where condition as an example, it is required that num must set the upper and lower bounds of the query
 func TestRegVarInSqlMatch(t *testing.T) {
 	cases := []struct {
@@ -236,3 +275,702 @@ func TestRegVarInSqlMatch(t *testing.T) {
 		})
 	}
 }
+
+// Inputs and programs too large for the bit vector must give the same
+// answers as the small ones, since only the backtracker understands variables.
+func TestRegexp_VarOnLongInput(t *testing.T) {
+	pad := strings.Repeat("zz", 20000)
+	cases := []struct {
+		name   string
+		reg    string
+		text   string
+		expect string
+	}{
+		{
+			"No.1", "a\\(${word}\\)b\\(${word}\\)cd", pad + "a(abc)b(def)cd e", "a(abc)b(def)cd",
+		},
+		{
+			"No.2", "a\\(${word}\\)b\\(${word}\\)cd", pad + "a(def)b(def)cd e", "",
+		},
+		{
+			"No.3", "^${word}$", "def", "def",
+		},
+		{
+			"No.4", "^${word}$", "deff", "",
+		},
+		{
+			"No.5", strings.Repeat("(?:x|y)", 300) + "${word}", strings.Repeat("xy", 150) + "abc", strings.Repeat("xy", 150) + "abc",
+		},
+	}
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			mustCompile := MustCompile(tt.reg)
+			mustCompile.RegisterStringVar("word", "abc", "def")
+			assert.Equal(t, mustCompile.FindString(tt.text), tt.expect)
+		})
+	}
+
+	mustCompile := MustCompile("a@{var}2b@{var}")
+	mustCompile.RegisterRegVar("var", MustCompile("\\d+"), MustCompile("[a-z]*"))
+	assert.Equal(t, mustCompile.FindString(pad+"a302bacR"), "a302bac")
+
+	// Matches spanning more input than the first window of the
+	// backtracker are searched again with larger windows.
+	mustCompile = MustCompile("${word}[a-z]*${word}x")
+	mustCompile.RegisterStringVar("word", "abc", "def")
+	assert.Equal(t, mustCompile.FindString("y"+"abc"+pad+"defxzz"), "abc"+pad+"defx")
+	mustCompile.Longest()
+	assert.Equal(t, mustCompile.FindString("y"+"abc"+pad+"defxdefx"), "abc"+pad+"defxdefx")
+
+	long := strings.Repeat(pad, 3)
+	mustCompile = MustCompile("^a@{var}b$")
+	mustCompile.RegisterRegVar("var", MustCompile("(?:y|z)*"))
+	assert.Equal(t, mustCompile.MatchString("a"+long+"b"), true)
+	assert.Equal(t, mustCompile.MatchString("a"+long+"c"), false)
+}
+
+func TestRegexp_VarConcurrent(t *testing.T) {
+	cases := []struct {
+		text   string
+		expect string
+	}{
+		{"a(abc)b(def)cd e", "a(abc)b(def)cd"},
+		{"a(def)b(abc)cd e", "a(def)b(abc)cd"},
+		{"a(def)b(def)cd e", ""},
+		{"a(abc)b(12)cd e", "a(abc)b(12)cd"},
+		{"a(x1)b(def)cd e", ""},
+	}
+	mustCompile := MustCompile("a\\(${word}\\)b\\((?:${word}|@{var})\\)cd")
+	mustCompile.RegisterStringVar("word", "abc", "def")
+	mustCompile.RegisterRegVar("var", MustCompile("\\d+"))
+	mustCompile.SetRegVarLimit("var", 0, 1)
+
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := 0; n < 200; n++ {
+				for _, tt := range cases {
+					if got := mustCompile.FindString(tt.text); got != tt.expect {
+						t.Errorf("FindString(%q) = %q, want %q", tt.text, got, tt.expect)
+						return
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// The same (pc, pos) can be reached with different variables consumed;
+// a failing visit must not prune a later one that would succeed.
+func TestRegexp_VarMemoization(t *testing.T) {
+	cases := []struct {
+		name     string
+		reg      string
+		text     string
+		strs     []string
+		min, max int
+		expect   string
+	}{
+		{
+			"No.1", "(?:${w}|ab)${w}", "abab", []string{"ab"}, 0, 2, "abab",
+		},
+		{
+			"No.2", "(?:${w}|a)(?:b|${w})c", "abc", []string{"a", "b"}, 2, 2, "abc",
+		},
+		{
+			"No.3", "(?:${w}|ab)(?:${w}x|${w}y)", "ababy", []string{"ab"}, 0, 2, "ababy",
+		},
+		{
+			"No.4", "(?:ab|${w})(?:${w}x|${w}y)", "ababy", []string{"ab"}, 0, 2, "ababy",
+		},
+		{
+			"No.5", "(?:${w}|a)*b${w}", "aabaa", []string{"aa"}, 0, 2, "aabaa",
+		},
+		{
+			"No.6", "(?:${w}|ab)*c", "ababc", []string{"ab"}, 2, 2, "",
+		},
+	}
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			mustCompile := MustCompile(tt.reg)
+			mustCompile.RegisterStringVar("w", tt.strs...)
+			mustCompile.SetStringVarLimit("w", tt.min, tt.max)
+			assert.Equal(t, mustCompile.FindString(tt.text), tt.expect)
+		})
+	}
+}
+
+func TestRegexp_InlineVarLimit(t *testing.T) {
+	cases := []struct {
+		name    string
+		reg     string
+		text    string
+		expect  string
+		wantErr string
+	}{
+		{
+			"No.1", "a\\(${word:2,3}\\)b\\(${word}\\)cd", "a(h中u)b(world)cdfg", "a(h中u)b(world)cd", "",
+		},
+		{
+			"No.2", "a\\(${word:3}\\)b\\(${word}\\)cd", "a(aad)b(world)cdfg", "", "",
+		},
+		{
+			"No.3", "a${word:0,0}?", "aaqw", "a", "",
+		},
+		{
+			"No.4", "${word:2,4}*", "abdec", "abde", "",
+		},
+		{
+			"No.5", "${word:2,}*", "xhelloaqwaadworld", "helloa", "",
+		},
+		{
+			"No.6", "${word:1,2}${word:2,3}", "", "", "error parsing regexp: invalid string variable bounds: `${word:2,3}`",
+		},
+		{
+			"No.7", "${word:3,2}", "", "", "error parsing regexp: invalid string variable bounds: `${word:3,2}`",
+		},
+	}
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			mustCompile, err := Compile(tt.reg)
+			if err != nil {
+				assert.Equal(t, err.Error(), tt.wantErr)
+				return
+			}
+			mustCompile.RegisterStringVar("word", []string{"hello", "h中u", "world", "aad", "aqw", "a", "b", "c", "d", "e"}...)
+			assert.Equal(t, mustCompile.FindString(tt.text), tt.expect)
+		})
+	}
+
+	// Limits set on the variable override those in the pattern.
+	mustCompile := MustCompile("a(@{var:3,3})2b(@{var})")
+	mustCompile.RegisterRegVar("var", MustCompile("\\d+"), MustCompile("[a-z]*"))
+	assert.Nil(t, mustCompile.FindStringSubmatch("a302bacR"))
+	mustCompile.SetRegVarLimit("var", 2, 3)
+	assert.Equal(t, mustCompile.FindStringSubmatch("a302bacR"), []string{"a302bac", "30", "ac"})
+	assert.Nil(t, mustCompile.With(NewBindings().RegVar("var", MustCompile("\\d+"), MustCompile("[a-z]*"))).FindStringSubmatch("a302bacR"))
+}
+
+func TestRegexp_StringVarFoldCase(t *testing.T) {
+	cases := []struct {
+		name   string
+		reg    string
+		fold   bool
+		text   string
+		strs   []string
+		expect string
+	}{
+		{
+			"No.1", "${word}", false, "xHeLLo", []string{"hello"}, "",
+		},
+		{
+			"No.2", "(?i)${word}", false, "xHeLLo", []string{"hello"}, "HeLLo",
+		},
+		{
+			"No.3", "(?i:${word})x${word}", false, "HELLOxworld", []string{"hello", "world"}, "HELLOxworld",
+		},
+		{
+			"No.4", "(?i:${word})x${word}", false, "HELLOxWORLD", []string{"hello", "world"}, "",
+		},
+		{
+			"No.5", "${word}", true, "xHeLLo", []string{"hello"}, "HeLLo",
+		},
+		{
+			// K folds to the Kelvin sign and ſ (long s) to s.
+			"No.6", "(?i)${word}", false, "Kiſs", []string{"kiss"}, "Kiſs",
+		},
+		{
+			// Both registered spellings are reachable and each is consumed once.
+			"No.7", "(?i)${word}-${word}", false, "ABC-abc", []string{"Abc", "aBC"}, "ABC-abc",
+		},
+		{
+			"No.8", "(?i)${word}-${word}", false, "ABC-abc", []string{"abc"}, "",
+		},
+	}
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			mustCompile := MustCompile(tt.reg)
+			mustCompile.RegisterStringVar("word", tt.strs...)
+			mustCompile.SetStringVarFoldCase("word", tt.fold)
+			assert.Equal(t, mustCompile.FindString(tt.text), tt.expect)
+		})
+	}
+
+	mustCompile := MustCompile("${word}")
+	b := NewBindings().StringVar("word", "hello")
+	assert.Equal(t, mustCompile.With(b.StringVarFoldCase("word", true)).FindString("HELLO"), "HELLO")
+	assert.Equal(t, mustCompile.With(b).FindString("HELLO"), "")
+}
+
+func TestRegexp_StringVarRef(t *testing.T) {
+	cases := []struct {
+		name   string
+		reg    string
+		text   string
+		strs   []string
+		expect string
+	}{
+		{
+			"No.1", "<${tag}>[^<]*</${=tag}>", "<div>x</div>", []string{"a", "b", "div"}, "<div>x</div>",
+		},
+		{
+			"No.2", "<${tag}>[^<]*</${=tag}>", "<div>x</b>", []string{"a", "b", "div"}, "",
+		},
+		{
+			// A reference reuses the value, it does not consume it.
+			"No.3", "${tag}-${=tag}-${=tag}", "a-a-a", []string{"a"}, "a-a-a",
+		},
+		{
+			// The binding to "ab" is undone when it fails.
+			"No.4", "${tag}-${=tag}", "abc-abc", []string{"ab", "abc"}, "abc-abc",
+		},
+		{
+			// A reference repeats the innermost binding.
+			"No.5", "${tag}${tag}-${=tag}", "ab-a", []string{"a", "b"}, "",
+		},
+		{
+			"No.6", "${tag}${tag}-${=tag}", "ab-b", []string{"a", "b"}, "ab-b",
+		},
+		{
+			"No.7", "${=tag}${tag}", "aa", []string{"a"}, "",
+		},
+		{
+			"No.8", "(?i)${tag}-${=tag}", "Div-dIV", []string{"div"}, "Div-dIV",
+		},
+		{
+			// Both paths reach "-" with the same value used, bound to
+			// "a" and to "A"; only the second one matches.
+			"No.9", "(?i:(?:${tag}|.)(?:${tag}|.))-${=tag}", "aA-A", []string{"a"}, "aA-A",
+		},
+	}
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			mustCompile := MustCompile(tt.reg)
+			mustCompile.RegisterStringVar("tag", tt.strs...)
+			assert.Equal(t, mustCompile.FindString(tt.text), tt.expect)
+		})
+	}
+}
+
+func TestRegexp_StringVarMode(t *testing.T) {
+	cases := []struct {
+		name   string
+		reg    string
+		mode   VarMode
+		limit  []int
+		text   string
+		expect string
+	}{
+		{
+			"No.1", "^${word}+$", Consuming, nil, "abab", "",
+		},
+		{
+			"No.2", "^${word}+$", Reusable, nil, "abab", "abab",
+		},
+		{
+			"No.3", "^${word}+$", Reusable, nil, "ababc", "",
+		},
+		{
+			"No.4", "^(?:${word},)+$", Reusable, nil, "ab,cd,ab,ab,", "ab,cd,ab,ab,",
+		},
+		{
+			"No.5", "^${word}+$", Reusable, []int{1, 2}, "ababab", "",
+		},
+		{
+			"No.6", "^${word}+$", Reusable, []int{3, 4}, "ababab", "ababab",
+		},
+		{
+			"No.7", "^${word}+$", Reusable, []int{3, 4}, "abab", "",
+		},
+	}
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			mustCompile := MustCompile(tt.reg)
+			mustCompile.RegisterStringVar("word", "ab", "cd")
+			mustCompile.SetStringVarMode("word", tt.mode)
+			if tt.limit != nil {
+				mustCompile.SetStringVarLimit("word", tt.limit[0], tt.limit[1])
+			}
+			assert.Equal(t, mustCompile.FindString(tt.text), tt.expect)
+		})
+	}
+
+	mustCompile := MustCompile("${word}+")
+	b := NewBindings().StringVar("word", "ab")
+	assert.Equal(t, mustCompile.With(b.StringVarMode("word", Reusable)).FindString("xababx"), "abab")
+	assert.Equal(t, mustCompile.With(b).FindString("xababx"), "ab")
+
+	// A reusable variable over a long input stays linear.
+	words := make([]string, 1000)
+	for i := range words {
+		words[i] = strconv.Itoa(i) + ","
+	}
+	long := MustCompile("^${word}+$")
+	long.RegisterStringVar("word", words...)
+	long.SetStringVarMode("word", Reusable)
+	text := strings.Repeat(strings.Join(words, ""), 20)
+	assert.Equal(t, long.MatchString(text), true)
+	assert.Equal(t, long.MatchString(text+"x"), false)
+}
+
+func TestRegexp_LongestVar(t *testing.T) {
+	cases := []struct {
+		name   string
+		reg    string
+		text   string
+		expect string
+	}{
+		{
+			"No.1", "${word}", "abcd", "abc",
+		},
+		{
+			"No.2", "${word}${word}", "abcd", "abcd",
+		},
+		{
+			"No.3", "${word}|abcd", "abcd", "abcd",
+		},
+		{
+			"No.4", "(${word}|b)*", "abcbab", "abcbab",
+		},
+		{
+			"No.5", "x@{var}", "x123ab", "x123ab",
+		},
+		{
+			// The longest value of the reg var is not the one that matches.
+			"No.6", "x@{var}ab", "x12ab3ab", "x12ab3ab",
+		},
+		{
+			"No.7", "x@{var}b", "xaab", "xaab",
+		},
+	}
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, mustCompile := range []*Regexp{MustCompilePOSIX(tt.reg), MustCompile(tt.reg)} {
+				mustCompile.Longest()
+				mustCompile.RegisterStringVar("word", "a", "b", "ab", "abc", "cd", "bcd")
+				mustCompile.RegisterRegVar("var", MustCompile("\\d+?"), MustCompile("\\d[a-z0-9]*?"), MustCompilePOSIX("a+"))
+				assert.Equal(t, mustCompile.FindString(tt.text), tt.expect)
+			}
+		})
+	}
+}
+
+// TestRegexp_LongestVarConformance checks leftmost-longest matches of
+// generated patterns mixing variables and literals against a brute force
+// search for the longest text matching the whole pattern.
+func TestRegexp_LongestVarConformance(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	atoms := []string{"a", "b", "b*", "(a|ab)", "${w}", "${w}?", "${w}*", "(${w}|b)", "(${w}a|a${w})",
+		"${w:1,2}", "${w}${=w}", "@{v}", "@{v}*", "(@{v}|${w})+"}
+	words := []string{"a", "ab", "ba", "b", "aab"}
+	for n := 0; n < 1000; n++ {
+		pattern := ""
+		for j := 1 + rnd.Intn(4); j > 0; j-- {
+			pattern += atoms[rnd.Intn(len(atoms))]
+		}
+		text := ""
+		for j := rnd.Intn(10); j > 0; j-- {
+			text += string("ab"[rnd.Intn(2)])
+		}
+		strs := words[:1+rnd.Intn(len(words))]
+		longest := MustCompilePOSIX(pattern)
+		whole := MustCompile("^(" + pattern + ")$")
+		for _, re := range []*Regexp{longest, whole} {
+			re.RegisterStringVar("w", strs...)
+			re.RegisterRegVar("v", MustCompile("a+?"), MustCompile("b"), MustCompile("(ab)*b?"), MustCompilePOSIX("a+|ab"))
+		}
+
+		var expect []int
+		for start := 0; start <= len(text) && expect == nil; start++ {
+			for end := len(text); end >= start; end-- {
+				if whole.MatchString(text[start:end]) {
+					expect = []int{start, end}
+					break
+				}
+			}
+		}
+		assert.Equal(t, longest.FindStringIndex(text), expect, "%s on %q with %q", pattern, text, strs)
+	}
+}
+
+func TestRegexp_StringVarPreference(t *testing.T) {
+	cases := []struct {
+		name   string
+		reg    string
+		pref   StringVarPreference
+		text   string
+		strs   []string
+		expect string
+	}{
+		{
+			"No.1", "${word}", Shortest, "abcdef", []string{"abcde", "abc"}, "abc",
+		},
+		{
+			"No.2", "${word}", Longest, "abcdef", []string{"abc", "abcde"}, "abcde",
+		},
+		{
+			"No.3", "${word}", RegistrationOrder, "abcdef", []string{"abcde", "a", "abc"}, "abcde",
+		},
+		{
+			"No.4", "${word}", RegistrationOrder, "abcdef", []string{"abc", "abcde"}, "abc",
+		},
+		{
+			// The preferred value is given up when the rest does not match.
+			"No.5", "${word}d", Longest, "abcdef", []string{"abc", "abcde"}, "abcd",
+		},
+		{
+			// Leftmost-first: an earlier start wins over a preferred value.
+			"No.6", "${word}", Longest, "xbcabcde", []string{"bc", "abcde"}, "bc",
+		},
+		{
+			// abc is consumed by the first occurrence.
+			"No.7", "${word}-${word}", Longest, "abc-abc", []string{"abc", "a", "ab"}, "abc-ab",
+		},
+		{
+			"No.8", "${word}${word}", Longest, "abcab", []string{"ab", "abc", "c"}, "abcab",
+		},
+		{
+			"No.9", "(?i)${word}", Longest, "ABCDE", []string{"abc", "abcde"}, "ABCDE",
+		},
+	}
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			mustCompile := MustCompile(tt.reg)
+			mustCompile.RegisterStringVar("word", tt.strs...)
+			mustCompile.SetStringVarPreference("word", tt.pref)
+			assert.Equal(t, mustCompile.FindString(tt.text), tt.expect)
+		})
+	}
+
+	mustCompile := MustCompile("${word}")
+	b := NewBindings().StringVar("word", "abc", "abcde")
+	assert.Equal(t, mustCompile.With(b.StringVarPreference("word", Longest)).FindString("abcdef"), "abcde")
+	assert.Equal(t, mustCompile.With(b).FindString("abcdef"), "abc")
+}
+
+func TestRegexp_RecursiveRegVar(t *testing.T) {
+	cases := []struct {
+		name   string
+		text   string
+		expect string
+	}{
+		{
+			"No.1", "x(a(b)(c(d)))y", "(a(b)(c(d)))",
+		},
+		{
+			"No.2", "(a(b)(c(d)", "(b)",
+		},
+		{
+			"No.3", "((((((((()))))))))", "((((((((()))))))))",
+		},
+		{
+			"No.4", ")(", "",
+		},
+	}
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			mustCompile := MustCompile(`\((?:[^()]|@{paren})*\)`)
+			mustCompile.RegisterRegVar("paren", mustCompile)
+			mustCompile.SetRegVarMode("paren", Reusable)
+			assert.Equal(t, mustCompile.FindString(tt.text), tt.expect)
+		})
+	}
+
+	// The searches for paren nest one level deeper than the parentheses.
+	paren := MustCompile(`\((?:[^()]|@{paren})*\)`)
+	paren.RegisterRegVar("paren", paren)
+	paren.SetRegVarMode("paren", Reusable)
+	whole := MustCompile(`^@{paren}$`)
+	whole.RegisterRegVar("paren", paren)
+	whole.SetRegVarMaxDepth(3)
+	assert.Equal(t, whole.MatchString("(((x)))"), true)
+	whole.SetRegVarMaxDepth(2)
+	assert.Equal(t, whole.MatchString("(((x)))"), false)
+	assert.Equal(t, whole.MatchString("((x))"), true)
+
+	// Mutually recursive brackets.
+	list := MustCompile(`\[(?:@{item}(?:,@{item})*)?\]`)
+	item := MustCompile(`\d+|@{list}`)
+	list.RegisterRegVar("item", item)
+	list.SetRegVarMode("item", Reusable)
+	item.RegisterRegVar("list", list)
+	item.SetRegVarMode("list", Reusable)
+	assert.Equal(t, list.FindString("x [1,[2,[]],[[3]]] y"), "[1,[2,[]],[[3]]]")
+
+	// A Regexp starting with its own reg variable does not recurse
+	// without consuming input.
+	sum := MustCompile(`^(?:@{sum}\+)?\d$`)
+	sum.RegisterRegVar("sum", sum)
+	assert.Equal(t, sum.MatchString("1+2"), false)
+	assert.Equal(t, sum.MatchString("1"), true)
+}
+
+func TestRegexp_ReusableRegVarLoop(t *testing.T) {
+	// The uses of an unlimited reusable reg variable repeated in a
+	// loop must not defeat the memoization of the backtracker.
+	mustCompile := MustCompile("^(?:@{v})*$")
+	mustCompile.RegisterRegVar("v", MustCompile("a*"))
+	mustCompile.SetRegVarMode("v", Reusable)
+	done := make(chan [2]bool, 1)
+	go func() {
+		done <- [2]bool{mustCompile.MatchString("aaab"), mustCompile.MatchString("aaaa")}
+	}()
+	select {
+	case matched := <-done:
+		assert.Equal(t, matched, [2]bool{false, true})
+	case <-time.After(10 * time.Second):
+		t.Fatal("match of a repeated reusable reg variable did not finish")
+	}
+}
+
+func TestRegexp_RemoveVarValues(t *testing.T) {
+	mustCompile := MustCompile("${word}+")
+	mustCompile.RegisterStringVarByMap("word", map[string]int{"ab": 2, "abc": 1})
+	mustCompile.SetStringVarLimit("word", 2, 3)
+	assert.Equal(t, mustCompile.FindString("abcab"), "abcab")
+
+	mustCompile.RemoveStringVarValues("word", "abc", "missing")
+	assert.Equal(t, mustCompile.FindString("abcab"), "")
+	assert.Equal(t, mustCompile.FindString("abab"), "abab")
+
+	// The limits are kept with the new values.
+	mustCompile.ReplaceStringVar("word", "x", "y")
+	assert.Equal(t, mustCompile.FindString("abab x"), "")
+	assert.Equal(t, mustCompile.FindString("abab xyx"), "xy")
+
+	mustCompile.UnregisterVar("word")
+	assert.Panics(t, func() { mustCompile.FindString("xy") })
+	assert.Panics(t, func() { mustCompile.RemoveStringVarValues("word", "x") })
+
+	regCompile := MustCompile("a@{num}")
+	regCompile.RegisterRegVar("num", MustCompile(`\d`))
+	regCompile.SetRegVarAnchored("num", true)
+	regCompile.ClearRegVar("num")
+	assert.Equal(t, regCompile.FindString("a1"), "")
+	regCompile.RegisterRegVar("num", MustCompile(`[a-z]`))
+	assert.Equal(t, regCompile.FindString("a1 ab"), "ab")
+}
+
+func TestRegexp_CopyVars(t *testing.T) {
+	mustCompile := MustCompile("a${word}@{num}")
+	mustCompile.RegisterStringVar("word", "bc")
+	inner := MustCompile(`${digit}+`)
+	inner.RegisterStringVar("digit", "1", "2")
+	inner.SetStringVarMode("digit", Reusable)
+	mustCompile.RegisterRegVar("num", inner)
+	mustCompile.SetRegVarAnchored("num", true)
+
+	copied := mustCompile.Copy()
+	copied.RegisterStringVar("word", "de")
+	copied.vars.regVar["num"].l.Front().Value.(*Regexp).RegisterStringVar("digit", "3")
+	assert.Equal(t, copied.FindString("ade3"), "ade3")
+	assert.Equal(t, mustCompile.FindString("abc3 abc12"), "abc12")
+	assert.Equal(t, mustCompile.FindString("ade3"), "")
+	assert.Equal(t, inner.FindString("3"), "")
+
+	// A recursive Regexp is copied once and registered on its copy.
+	paren := MustCompile(`\((?:[^()]|@{paren})*\)`)
+	paren.RegisterRegVar("paren", paren)
+	paren.SetRegVarMode("paren", Reusable)
+	parenCopy := paren.Copy()
+	assert.Equal(t, parenCopy.vars.regVar["paren"].l.Front().Value.(*Regexp), parenCopy)
+	assert.Equal(t, parenCopy.FindString("x((a)(b))y"), "((a)(b))")
+}
+
+func TestRegexp_VarMinInputLen(t *testing.T) {
+	mustCompile := MustCompile("a${word}(@{num}|b)${=word}")
+	assert.Equal(t, mustCompile.varMinInputLen(), 1)
+
+	mustCompile.RegisterStringVar("word", "hello", "héllo")
+	mustCompile.RegisterRegVar("num", MustCompile(`\d\d\d`))
+	assert.Equal(t, mustCompile.varMinInputLen(), 12)
+	mustCompile.RegisterStringVar("word", "hi")
+	assert.Equal(t, mustCompile.varMinInputLen(), 6)
+	mustCompile.ReplaceStringVar("word", "Kelvin")
+	assert.Equal(t, mustCompile.varMinInputLen(), 14)
+	mustCompile.ClearRegVar("num")
+	assert.Equal(t, mustCompile.varMinInputLen(), 13)
+
+	// Case folding may match a shorter rune: K is also the Kelvin sign.
+	foldCompile := MustCompile("(?i)${word}")
+	foldCompile.RegisterStringVar("word", "K")
+	assert.Equal(t, foldCompile.varMinInputLen(), 1)
+	assert.Equal(t, foldCompile.FindString("k"), "k")
+}
+
+func TestRegexp_RequiredLiteral(t *testing.T) {
+	cases := []struct {
+		name   string
+		reg    string
+		expect string
+	}{
+		{
+			"No.1", "${user}@example\\.com", "@example.com",
+		},
+		{
+			"No.2", "a${word}(bcd)+(?:efgh)?", "bcd",
+		},
+		{
+			"No.3", "(?i)${word}@example", "",
+		},
+		{
+			"No.4", "${word}(?:ab|cd)", "",
+		},
+		{
+			"No.5", "x{2}${word}", "xx",
+		},
+		{
+			"No.6", "xy\\x{FFFD}${word}", "xy",
+		},
+		{
+			"No.7", "${word}\\x{FFFD}", "",
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			mustCompile := MustCompile(tt.reg)
+			assert.Equal(t, mustCompile.required, tt.expect)
+			mustCompile.RegisterStringVar("word", "ab")
+			mustCompile.RegisterStringVar("user", "ab")
+			if tt.expect != "" {
+				assert.Equal(t, mustCompile.cannotMatch(nil, "ab ab ab", 0), true)
+				assert.Equal(t, mustCompile.cannotMatch([]byte("ab "+tt.expect), "", 0), false)
+				assert.Equal(t, mustCompile.cannotMatch(nil, "ab "+tt.expect, 4), true)
+			}
+		})
+	}
+
+	// U+FFFD also matches an invalid byte of the input, which is shorter.
+	invalid := []struct {
+		name   string
+		reg    string
+		value  string
+		text   string
+		expect []int
+	}{
+		{
+			"No.1", "${word}\\x{FFFD}", "a", "a\xff", []int{0, 2},
+		},
+		{
+			"No.2", "${word}", "\uFFFD", "\xff", []int{0, 1},
+		},
+		{
+			"No.3", "${word}b\\x{FFFD}c", "a", "ab\xffc", []int{0, 4},
+		},
+		{
+			"No.4", "${word}(?:b\\x{FFFD}){2}", "a", "ab\xffb\xff", []int{0, 5},
+		},
+	}
+	for _, tt := range invalid {
+		t.Run("Invalid"+tt.name, func(t *testing.T) {
+			mustCompile := MustCompile(tt.reg)
+			mustCompile.RegisterStringVar("word", tt.value)
+			assert.Equal(t, mustCompile.FindStringIndex(tt.text), tt.expect)
+			assert.Equal(t, mustCompile.FindIndex([]byte(tt.text)), tt.expect)
+		})
+	}
+}
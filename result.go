@@ -0,0 +1,174 @@
+package regPlus
+
+import (
+	"math"
+	"sort"
+)
+
+// A MatchResult is a match of a Regexp together with the variable
+// occurrences it is made of. Its Inventory reports what the match left
+// of the registered values.
+type MatchResult struct {
+	// Index holds the index pairs of the match and its submatches,
+	// as returned by FindStringSubmatchIndex.
+	Index []int
+
+	// Vars holds the variable occurrences of the match,
+	// as returned by FindStringVarMatches.
+	Vars []VarMatch
+
+	re       *Regexp
+	consumed *consumption // values used up, including by earlier matches of a Session
+}
+
+// A VarInventory tells how a match used a variable of the pattern.
+type VarInventory struct {
+	Name string
+	Kind VarKind
+
+	// Used is the number of occurrences of the variable in the match,
+	// not counting ${=name} references. Min and Max are its limits and
+	// Remaining the number of further uses Max allows, or -1 if the
+	// variable is not limited.
+	Used      int
+	Min, Max  int
+	Remaining int
+
+	// Values lists the registered values of the variable in
+	// registration order.
+	Values []ValueInventory
+}
+
+// A ValueInventory tells how much of a registered value a match left.
+type ValueInventory struct {
+	// Value is the registered string of a string variable, or the
+	// source text of the Regexp registered on a reg variable, which is
+	// also held in Regexp.
+	Value  string
+	Regexp *Regexp
+
+	// Registered is the number of times the value was registered,
+	// always 1 for a Regexp, and Remaining the number of times it may
+	// still be used. Values of Reusable variables are never used up.
+	Registered int
+	Remaining  int
+}
+
+// FindStringResult returns the leftmost match of re in s as a
+// MatchResult, or nil if there is no match.
+func (re *Regexp) FindStringResult(s string) *MatchResult {
+	return re.findStringResult(newConsumption(), s)
+}
+
+// FindStringResult is like Regexp.FindStringResult within the session;
+// the Inventory of the result includes the values used up by the earlier
+// matches of the session.
+func (s *Session) FindStringResult(str string) *MatchResult {
+	return s.re.findStringResult(s.c, str)
+}
+
+// findStringResult matches re against s without the values consumed in c,
+// adding those consumed by the match to c.
+func (re *Regexp) findStringResult(c *consumption, s string) *MatchResult {
+	a, vars, uses := re.doExecuteVars(c, nil, s, 0, re.prog.NumCap)
+	if a == nil {
+		return nil
+	}
+	c.record(re, uses)
+	return &MatchResult{Index: re.pad(a), Vars: vars, re: re, consumed: c.clone()}
+}
+
+// Inventory returns, for each registered variable of the pattern in order
+// of first appearance, how many times the match used it and how many
+// times each of its values remains available.
+func (m *MatchResult) Inventory() []VarInventory {
+	var inv []VarInventory
+	for _, info := range m.re.varInfo {
+		v := VarInventory{Name: info.Name, Kind: info.Kind}
+		for _, vm := range m.Vars {
+			if vm.Name == info.Name && vm.Kind == info.Kind && !vm.Ref {
+				v.Used++
+			}
+		}
+		if info.Kind == StringVarKind {
+			t := m.re.vars.stringVar[info.Name]
+			if t == nil {
+				continue
+			}
+			v.Min, v.Max = m.re.stringVarLimit(info.Name, t)
+			strs, nodes := registeredValues(t.root)
+			for i, n := range nodes {
+				val := ValueInventory{Value: strs[i], Registered: n.Cnt, Remaining: n.Cnt}
+				if t.mode == Consuming {
+					val.Remaining -= m.consumed.used[n]
+				}
+				v.Values = append(v.Values, val)
+			}
+		} else {
+			r := m.re.vars.regVar[info.Name]
+			if r == nil {
+				continue
+			}
+			v.Min, v.Max = m.re.regVarLimit(info.Name, r)
+			for e := r.l.Front(); e != nil && e != &r.l.root; e = e.Next() {
+				reg := e.Value.(*Regexp)
+				val := ValueInventory{Value: reg.String(), Regexp: reg, Registered: 1, Remaining: 1}
+				if r.mode == Consuming && m.consumed.regUsed[e] {
+					val.Remaining = 0
+				}
+				v.Values = append(v.Values, val)
+			}
+		}
+		v.Remaining = -1
+		if v.Max != math.MaxInt64 {
+			v.Remaining = v.Max - v.Used
+		}
+		inv = append(inv, v)
+	}
+	return inv
+}
+
+// clone returns a copy of c.
+func (c *consumption) clone() *consumption {
+	n := newConsumption()
+	for k, v := range c.used {
+		n.used[k] = v
+	}
+	for k, v := range c.regUsed {
+		n.regUsed[k] = v
+	}
+	return n
+}
+
+// registeredValues returns the values registered in the tree rooted at t
+// and the nodes they end at, in registration order.
+func registeredValues(t *node) ([]string, []*node) {
+	var strs []string
+	var nodes []*node
+	var walk func(n *node, prefix []rune)
+	walk = func(n *node, prefix []rune) {
+		if n.Cnt > 0 {
+			strs = append(strs, string(prefix))
+			nodes = append(nodes, n)
+		}
+		for j, child := range n.kids {
+			walk(child, append(prefix, n.keys[j]))
+		}
+	}
+	walk(t, nil)
+	sort.Sort(byValueSeq{strs, nodes})
+	return strs, nodes
+}
+
+// byValueSeq sorts the values found by registeredValues by their seq.
+type byValueSeq struct {
+	strs  []string
+	nodes []*node
+}
+
+func (s byValueSeq) Len() int           { return len(s.nodes) }
+func (s byValueSeq) Less(i, j int) bool { return s.nodes[i].seq < s.nodes[j].seq }
+func (s byValueSeq) Swap(i, j int) {
+	s.strs[i], s.strs[j] = s.strs[j], s.strs[i]
+	s.nodes[i], s.nodes[j] = s.nodes[j], s.nodes[i]
+}
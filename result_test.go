@@ -0,0 +1,48 @@
+package regPlus
+
+import (
+	"github.com/stretchr/testify/assert"
+	"math"
+	"testing"
+)
+
+func TestMatchResult_Inventory(t *testing.T) {
+	digits := MustCompile(`\d+`)
+	mustCompile := MustCompile("a${word}b${word}c${=word}@{num}")
+	mustCompile.RegisterStringVarByMap("word", map[string]int{"x": 2})
+	mustCompile.RegisterStringVar("word", "yz")
+	mustCompile.SetStringVarLimit("word", 1, 3)
+	mustCompile.RegisterRegVar("num", digits)
+
+	result := mustCompile.FindStringResult("-axbyzcyz12-")
+	assert.Equal(t, result.Index, []int{1, 11})
+	assert.Equal(t, len(result.Vars), 4)
+	assert.Equal(t, result.Inventory(), []VarInventory{
+		{Name: "word", Kind: StringVarKind, Used: 2, Min: 1, Max: 3, Remaining: 1, Values: []ValueInventory{
+			{Value: "x", Registered: 2, Remaining: 1},
+			{Value: "yz", Registered: 1, Remaining: 0},
+		}},
+		{Name: "num", Kind: RegVarKind, Used: 1, Max: math.MaxInt64, Remaining: -1, Values: []ValueInventory{
+			{Value: `\d+`, Regexp: digits, Registered: 1, Remaining: 0},
+		}},
+	})
+
+	assert.Equal(t, mustCompile.FindStringResult("axbxc"), (*MatchResult)(nil))
+}
+
+func TestSession_FindStringResult(t *testing.T) {
+	mustCompile := MustCompile("${word}")
+	mustCompile.RegisterStringVarByMap("word", map[string]int{"ab": 2})
+	session := mustCompile.NewSession()
+
+	session.FindStringResult("ab")
+	result := session.FindStringResult("ab")
+	assert.Equal(t, result.Inventory()[0].Values, []ValueInventory{{Value: "ab", Registered: 2, Remaining: 0}})
+	assert.Equal(t, session.FindStringResult("ab"), (*MatchResult)(nil))
+
+	// A result does not change with later matches of the session.
+	session.Reset()
+	session.FindString("ab")
+	assert.Equal(t, result.Inventory()[0].Values[0].Remaining, 0)
+	assert.Equal(t, mustCompile.FindStringResult("ab").Inventory()[0].Values[0].Remaining, 1)
+}
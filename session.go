@@ -0,0 +1,192 @@
+package regPlus
+
+// consumption records the values of variables used up by earlier
+// matches, which later matches sharing it may no longer use.
+type consumption struct {
+	used    map[*node]int     // consumed count of each trie value
+	regUsed map[*Element]bool // reg variable values already used
+}
+
+func newConsumption() *consumption {
+	return &consumption{used: map[*node]int{}, regUsed: map[*Element]bool{}}
+}
+
+// seed marks the values consumed by earlier matches as used in v.
+func (c *consumption) seed(v *varState) {
+	for n, k := range c.used {
+		v.use(n, k)
+	}
+	for e := range c.regUsed {
+		v.useReg(e, true)
+	}
+}
+
+// record adds the values consumed by the occurrences of a match.
+// Values of Reusable variables and ${=name} references use nothing.
+func (c *consumption) record(re *Regexp, uses []varUse) {
+	for _, u := range uses {
+		switch {
+		case u.ref:
+		case u.elem != nil:
+			if re.vars.regVar[u.inst.Str].mode == Consuming {
+				c.regUsed[u.elem] = true
+			}
+		case u.node != nil:
+			if re.vars.stringVar[u.inst.Str].mode == Consuming {
+				c.used[u.node]++
+			}
+		}
+	}
+}
+
+// SetConsumeAcrossMatches sets whether the FindAll methods of re carry the
+// consumption of variable values from one match over to the next, so that
+// a value registered n times is found at most n times in the whole input
+// rather than in each match. Each call starts with every value available;
+// use a Session to carry consumption across calls.
+func (re *Regexp) SetConsumeAcrossMatches(across bool) {
+	re.consumeAcross = across
+}
+
+// A Session matches a Regexp against successive inputs, carrying the
+// consumption of variable values over from each match to the next:
+// a value registered n times is found at most n times over all the
+// matches of the session until Reset is called. The values themselves
+// are those registered on the Regexp when it is matched.
+//
+// A Session is not safe for concurrent use.
+type Session struct {
+	re *Regexp
+	c  *consumption
+}
+
+// NewSession returns a new Session matching re, with every value of its
+// variables available.
+func (re *Regexp) NewSession() *Session {
+	return &Session{re: re, c: newConsumption()}
+}
+
+// Reset makes every value of the variables available again.
+func (s *Session) Reset() {
+	s.c = newConsumption()
+}
+
+// Remaining returns, for each value registered on the string variable,
+// how many more times the session may match it. Values of a Reusable
+// variable are never used up and keep their registered counts.
+// It returns nil if the variable is unregistered.
+func (s *Session) Remaining(variable string) map[string]int {
+	t := s.re.vars.stringVar[variable]
+	if t == nil {
+		return nil
+	}
+	m := map[string]int{}
+	strs, nodes := registeredValues(t.root)
+	for i, n := range nodes {
+		m[strs[i]] = n.Cnt - s.c.used[n]
+	}
+	return m
+}
+
+// RemainingRegVar returns the Regexps registered on the reg variable that
+// the session may still use, in registration order. All of them remain
+// for a Reusable variable. It returns nil if the variable is unregistered.
+func (s *Session) RemainingRegVar(variable string) []*Regexp {
+	r := s.re.vars.regVar[variable]
+	if r == nil {
+		return nil
+	}
+	regs := []*Regexp{}
+	for e := r.l.Front(); e != nil && e != &r.l.root; e = e.Next() {
+		if r.mode == Reusable || !s.c.regUsed[e] {
+			regs = append(regs, e.Value.(*Regexp))
+		}
+	}
+	return regs
+}
+
+// execute is like Regexp.doExecute within the session, using up the
+// values of the match if there is one.
+func (s *Session) execute(str string, ncap int) []int {
+	a, _, uses := s.re.doExecuteVars(s.c, nil, str, 0, ncap)
+	if a != nil {
+		s.c.record(s.re, uses)
+	}
+	return a
+}
+
+// MatchString reports whether s contains a match of the regular
+// expression, using up the values of the match if it does.
+func (s *Session) MatchString(str string) bool {
+	return s.execute(str, 2) != nil
+}
+
+// FindString is like Regexp.FindString within the session.
+func (s *Session) FindString(str string) string {
+	a := s.execute(str, 2)
+	if a == nil {
+		return ""
+	}
+	return str[a[0]:a[1]]
+}
+
+// FindStringIndex is like Regexp.FindStringIndex within the session.
+func (s *Session) FindStringIndex(str string) []int {
+	a := s.execute(str, 2)
+	if a == nil {
+		return nil
+	}
+	return a[0:2]
+}
+
+// FindStringSubmatch is like Regexp.FindStringSubmatch within the session.
+func (s *Session) FindStringSubmatch(str string) []string {
+	a := s.execute(str, s.re.prog.NumCap)
+	if a == nil {
+		return nil
+	}
+	ret := make([]string, 1+s.re.numSubexp)
+	for i := range ret {
+		if 2*i < len(a) && a[2*i] >= 0 {
+			ret[i] = str[a[2*i]:a[2*i+1]]
+		}
+	}
+	return ret
+}
+
+// FindAllString is like Regexp.FindAllString within the session.
+func (s *Session) FindAllString(str string, n int) []string {
+	if n < 0 {
+		n = len(str) + 1
+	}
+	var result []string
+	s.re.allVarMatchesFrom(s.c, str, nil, n, false, func(match []int, _ []VarMatch) {
+		result = append(result, str[match[0]:match[1]])
+	})
+	return result
+}
+
+// FindAllStringIndex is like Regexp.FindAllStringIndex within the session.
+func (s *Session) FindAllStringIndex(str string, n int) [][]int {
+	if n < 0 {
+		n = len(str) + 1
+	}
+	var result [][]int
+	s.re.allVarMatchesFrom(s.c, str, nil, n, false, func(match []int, _ []VarMatch) {
+		result = append(result, match[0:2])
+	})
+	return result
+}
+
+// FindAllStringVarMatches is like Regexp.FindAllStringVarMatches within
+// the session.
+func (s *Session) FindAllStringVarMatches(str string, n int) [][]VarMatch {
+	if n < 0 {
+		n = len(str) + 1
+	}
+	var result [][]VarMatch
+	s.re.allVarMatchesFrom(s.c, str, nil, n, true, func(_ []int, vars []VarMatch) {
+		result = append(result, vars)
+	})
+	return result
+}
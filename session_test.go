@@ -0,0 +1,97 @@
+package regPlus
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestRegexp_SetConsumeAcrossMatches(t *testing.T) {
+	cases := []struct {
+		name   string
+		across bool
+		mode   VarMode
+		expect []string
+	}{
+		{
+			"No.1", false, Consuming, []string{"ab", "cd", "ab", "cd", "cd"},
+		},
+		{
+			"No.2", true, Consuming, []string{"ab", "cd", "cd"},
+		},
+		{
+			"No.3", true, Reusable, []string{"ab", "cd", "ab", "cd", "cd"},
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			mustCompile := MustCompile("${word}")
+			mustCompile.RegisterStringVarByMap("word", map[string]int{"ab": 1, "cd": 2})
+			mustCompile.SetStringVarMode("word", tt.mode)
+			mustCompile.SetConsumeAcrossMatches(tt.across)
+			assert.Equal(t, mustCompile.FindAllString("ab cd ab cd cd", -1), tt.expect)
+			// Every call starts afresh.
+			assert.Equal(t, mustCompile.FindAllString("ab cd ab cd cd", -1), tt.expect)
+		})
+	}
+}
+
+func TestSession(t *testing.T) {
+	mustCompile := MustCompile("${word}")
+	mustCompile.RegisterStringVar("word", "ab", "cd")
+	session := mustCompile.NewSession()
+
+	assert.Equal(t, session.FindAllString("ab cd ab", -1), []string{"ab", "cd"})
+	assert.Equal(t, session.Remaining("word"), map[string]int{"ab": 0, "cd": 0})
+	assert.Equal(t, session.MatchString("cd"), false)
+	assert.Equal(t, session.Remaining("missing"), map[string]int(nil))
+
+	session.Reset()
+	assert.Equal(t, session.FindString("x cd ab"), "cd")
+	assert.Equal(t, session.FindStringIndex("x cd ab"), []int{5, 7})
+	assert.Equal(t, session.Remaining("word"), map[string]int{"ab": 0, "cd": 0})
+	assert.Equal(t, session.FindString("x cd ab"), "")
+
+	// The Regexp itself is not affected by the session.
+	assert.Equal(t, mustCompile.FindString("ab"), "ab")
+}
+
+func TestSession_RegVar(t *testing.T) {
+	digits, letters := MustCompile(`\d+`), MustCompile(`[a-z]+`)
+	mustCompile := MustCompile(`<@{tok}>`)
+	mustCompile.RegisterRegVar("tok", digits, letters)
+	mustCompile.SetRegVarAnchored("tok", true)
+	session := mustCompile.NewSession()
+
+	assert.Equal(t, session.FindStringSubmatch("<12>"), []string{"<12>"})
+	assert.Equal(t, session.RemainingRegVar("tok"), []*Regexp{letters})
+	assert.Equal(t, session.FindAllString("<34> <ab> <cd>", -1), []string{"<ab>"})
+	assert.Equal(t, session.RemainingRegVar("tok"), []*Regexp{})
+
+	vars := mustCompile.NewSession().FindAllStringVarMatches("<1> <2> <a>", -1)
+	assert.Equal(t, len(vars), 2)
+	assert.Equal(t, vars[0][0].Value, "1")
+	assert.Equal(t, vars[1][0].Value, "a")
+}
+
+func TestSession_RejectedEmptyMatch(t *testing.T) {
+	// The empty match right after "a" is skipped and must leave the
+	// value of v for the one at the end of the input.
+	newRegexp := func() *Regexp {
+		mustCompile := MustCompile("a|@{v}")
+		mustCompile.RegisterRegVar("v", MustCompile("x*"))
+		return mustCompile
+	}
+
+	mustCompile := newRegexp()
+	mustCompile.SetConsumeAcrossMatches(true)
+	assert.Equal(t, mustCompile.FindAllString("ab", -1), []string{"a", ""})
+
+	session := newRegexp().NewSession()
+	assert.Equal(t, session.FindAllStringIndex("ab", -1), [][]int{{0, 1}, {2, 2}})
+	assert.Equal(t, len(session.RemainingRegVar("v")), 0)
+
+	session.Reset()
+	assert.Equal(t, session.FindAllString("a", -1), []string{"a"})
+	assert.Equal(t, len(session.RemainingRegVar("v")), 1)
+}
@@ -68,6 +68,7 @@ const (
 	UnicodeGroups                   // allow \p{Han}, \P{Han} for Unicode group and negation
 	WasDollar                       // regexp OpEndText was $, not \z
 	Simple                          // regexp contains no counted repetition
+	VarRef                          // regexp OpStringVar was ${=name}, repeating the bound value
 
 	MatchNL = ClassNL | DotNL
 
@@ -1706,11 +1707,53 @@ func (p *parser) parseVar(s string, op Op) (rest string, err error) {
 		return "", &Error{ErrMissingBrace, s}
 	}
 	re.Var = s[2:end]
+	re.Min, re.Max = 0, -1
+	if strings.HasPrefix(re.Var, "=") {
+		// Reference to the value bound by an earlier ${name}: ${=name}.
+		// It uses no value of its own, so it takes no limits.
+		if op != OpStringVar || strings.IndexByte(re.Var, ':') >= 0 {
+			return "", &Error{ErrInvalidStringVar, s[:end+1]}
+		}
+		re.Var = re.Var[1:]
+		re.Flags |= VarRef
+	}
+	if i := strings.IndexByte(re.Var, ':'); i >= 0 {
+		// Limits on the number of uses: ${name:min,max}, ${name:min,} or ${name:n}.
+		min, max, ok := p.parseVarBounds(re.Var[i+1:])
+		if !ok {
+			return "", &Error{ErrInvalidStringVarBounds, s[:end+1]}
+		}
+		re.Var = re.Var[:i]
+		re.Min, re.Max = min, max
+	}
 	rest = s[end+1:]
 	p.push(re)
 	return
 }
 
+// parseVarBounds parses the limits of a variable, "min,max", "min," or "n".
+// A max of -1 means no upper limit.
+func (p *parser) parseVarBounds(s string) (min, max int, ok bool) {
+	var ok1 bool
+	if min, s, ok1 = p.parseInt(s); !ok1 || min < 0 {
+		return
+	}
+	switch {
+	case s == "":
+		max = min
+	case s == ",":
+		max = -1
+	case s[0] == ',':
+		if max, s, ok1 = p.parseInt(s[1:]); !ok1 || s != "" || max < min {
+			return
+		}
+	default:
+		return
+	}
+	ok = true
+	return
+}
+
 // cleanClass sorts the ranges (pairs of elements of r),
 // merges them, and eliminates duplicates.
 func cleanClass(rp *[]rune) []rune {
@@ -212,6 +212,16 @@ var parseTests = []parseTest{
 	{strings.Repeat("(", 999) + strings.Repeat(")", 999), ``},
 	{strings.Repeat("(?:", 999) + strings.Repeat(")*", 999), ``},
 	{"(" + strings.Repeat("|", 12345) + ")", ``}, // not nested at all
+
+	// Variables
+	{`${word}`, `svar{word}`},
+	{`@{var}`, `rvar{var}`},
+	{`a${word}b`, `cat{lit{a}svar{word}lit{b}}`},
+	{`${word:2,4}`, `svar{word 2,4}`},
+	{`${word:3}`, `svar{word 3,3}`},
+	{`@{var:1,}`, `rvar{var 1,-1}`},
+	{`${word:0,}`, `svar{word}`},
+	{`<${tag}></${=tag}>`, `cat{lit{<}svar{tag}Str{></}svar{=tag}lit{>}}`},
 }
 
 const testFlags = MatchNL | PerlX | UnicodeGroups
@@ -313,6 +323,8 @@ var opNames = []string{
 	OpRepeat:         "rep",
 	OpConcat:         "cat",
 	OpAlternate:      "alt",
+	OpStringVar:      "svar",
+	OpRegVar:         "rvar",
 }
 
 // dumpRegexp writes an encoding of the syntax tree for the regexp re to b.
@@ -371,6 +383,14 @@ func dumpRegexp(b *strings.Builder, re *Regexp) {
 			b.WriteByte(':')
 		}
 		dumpRegexp(b, re.Sub[0])
+	case OpStringVar, OpRegVar:
+		if re.Flags&VarRef != 0 {
+			b.WriteByte('=')
+		}
+		b.WriteString(re.Var)
+		if re.Min != 0 || re.Max != -1 {
+			fmt.Fprintf(b, " %d,%d", re.Min, re.Max)
+		}
 	case OpCharClass:
 		sep := ""
 		for i := 0; i < len(re.Rune); i += 2 {
@@ -490,6 +510,15 @@ var invalidRegexps = []string{
 	strings.Repeat("(", 1000) + strings.Repeat(")", 1000),
 	strings.Repeat("(?:", 1000) + strings.Repeat(")*", 1000),
 	`\Q\E*`,
+	`${word`,
+	`${word:}`,
+	`${word:a}`,
+	`${word:2,1}`,
+	`${word:1,2,3}`,
+	`${=word:1,2}`,
+	`@{=var}`,
+	`@{var:-1}`,
+	`@{var:,2}`,
 }
 
 var onlyPerl = []string{
@@ -21,10 +21,10 @@ type Regexp struct {
 	Sub0     [1]*Regexp // storage for short Sub
 	Rune     []rune     // matched runes, for OpLiteral, OpCharClass
 	Rune0    [2]rune    // storage for short Rune
-	Min, Max int        // min, max for OpRepeat
+	Min, Max int        // min, max for OpRepeat; use limits for OpStringVar, OpRegVar
 	Cap      int        // capturing index, for OpCapture
 	Name     string     // capturing name, for OpCapture
-	Var      string
+	Var      string     // variable name, for OpStringVar, OpRegVar
 }
 
 //go:generate stringer -type Op -trimprefix Op
@@ -57,8 +57,8 @@ const (
 	OpConcat                       // matches concatenation of Subs
 	OpAlternate                    // matches alternation of Subs
 
-	OpStringVar
-	OpRegVar
+	OpStringVar // matches a string registered on variable Var, used Min to Max times (Max == -1 is no limit); with VarRef, the value bound by the previous occurrence
+	OpRegVar    // matches a regexp registered on variable Var, used Min to Max times (Max == -1 is no limit)
 )
 
 const opPseudo Op = 128 // where pseudo-ops start
@@ -112,6 +112,11 @@ func (x *Regexp) Equal(y *Regexp) bool {
 		if x.Cap != y.Cap || x.Name != y.Name || !x.Sub[0].Equal(y.Sub[0]) {
 			return false
 		}
+
+	case OpStringVar, OpRegVar:
+		if x.Var != y.Var || x.Flags&VarRef != y.Flags&VarRef || x.Min != y.Min || x.Max != y.Max {
+			return false
+		}
 	}
 	return true
 }
@@ -121,6 +126,23 @@ func writeRegexp(b *strings.Builder, re *Regexp) {
 	switch re.Op {
 	default:
 		b.WriteString("<invalid op" + strconv.Itoa(int(re.Op)) + ">")
+	case OpStringVar, OpRegVar:
+		if re.Op == OpStringVar {
+			b.WriteString(`${`)
+		} else {
+			b.WriteString(`@{`)
+		}
+		if re.Flags&VarRef != 0 {
+			b.WriteString(`=`)
+		}
+		b.WriteString(re.Var)
+		if re.Min != 0 || re.Max != -1 {
+			b.WriteString(`:` + strconv.Itoa(re.Min) + `,`)
+			if re.Max >= 0 {
+				b.WriteString(strconv.Itoa(re.Max))
+			}
+		}
+		b.WriteString(`}`)
 	case OpNoMatch:
 		b.WriteString(`[^\x00-\x{10FFFF}]`)
 	case OpEmptyMatch:
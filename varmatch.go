@@ -0,0 +1,177 @@
+package regPlus
+
+import "github.com/koleter/regPlus/syntax"
+
+// A VarKind tells string variables (${name}) and reg variables (@{name}) apart.
+type VarKind uint8
+
+const (
+	StringVarKind VarKind = iota // ${name}
+	RegVarKind                   // @{name}
+)
+
+func (k VarKind) String() string {
+	if k == RegVarKind {
+		return "RegVar"
+	}
+	return "StringVar"
+}
+
+// A VarMatch describes the value taken by one occurrence of a variable
+// in a match.
+type VarMatch struct {
+	Name string
+	Kind VarKind
+
+	// Occurrence counts the uses of the variable within the match,
+	// starting at 1 for the leftmost one. A ${=name} reference has
+	// the Occurrence of the value it repeats.
+	Occurrence int
+
+	// Ref reports whether the occurrence is a ${=name} reference.
+	Ref bool
+
+	// Value is the text of the input matched by the occurrence. For a
+	// string variable matched case-insensitively it may differ in case
+	// from the registered string.
+	Value string
+
+	// RegexpIndex is the position, in registration order, of the Regexp
+	// used by a reg variable occurrence; it is -1 for string variables.
+	RegexpIndex int
+
+	// Start and End are the byte offsets of the occurrence in the input.
+	Start, End int
+
+	// Submatch and SubmatchIndex hold the groups of the Regexp used by a
+	// reg variable occurrence, as its FindStringSubmatch and
+	// FindStringSubmatchIndex would, with offsets in the input of the
+	// outer match. SubexpNames are the names of those groups. They are
+	// nil for string variables and for Regexps without groups.
+	Submatch      []string
+	SubmatchIndex []int
+	SubexpNames   []string
+}
+
+// SubmatchByName returns the text of the group of the reg variable
+// occurrence named name, or "" if there is no such group or it did not
+// take part in the match.
+func (m VarMatch) SubmatchByName(name string) string {
+	for i, n := range m.SubexpNames {
+		if n == name && n != "" && i < len(m.Submatch) {
+			return m.Submatch[i]
+		}
+	}
+	return ""
+}
+
+// A varUse records the value chosen for one variable occurrence
+// on the current backtracking path.
+type varUse struct {
+	inst       *syntax.Inst
+	occurrence int
+	elem       *Element // value of a reg variable, nil for string variables
+	node       *node    // consumed value of a string variable, if any
+	ref        bool     // ${=name} reference to an earlier occurrence
+	start, end int
+	caps       []int // groups of a reg variable value, nil if it has none
+}
+
+// FindStringVarMatches returns the variable occurrences of the leftmost match
+// of re in s, in the order they appear in the match. A nil return value
+// indicates no match; a match without variables returns an empty slice.
+func (re *Regexp) FindStringVarMatches(s string) []VarMatch {
+	_, vars, _ := re.doExecuteVars(nil, nil, s, 0, 2)
+	return vars
+}
+
+// FindAllStringVarMatches is the 'All' version of FindStringVarMatches; it
+// returns a slice of the variable occurrences of successive non-overlapping
+// matches, as defined by the 'All' description in the package comment.
+// A return value of nil indicates no match.
+func (re *Regexp) FindAllStringVarMatches(s string, n int) [][]VarMatch {
+	if n < 0 {
+		n = len(s) + 1
+	}
+	var result [][]VarMatch
+	re.allVarMatches(s, nil, n, true, func(match []int, vars []VarMatch) {
+		if result == nil {
+			result = make([][]VarMatch, 0, startSize)
+		}
+		result = append(result, vars)
+	})
+	return result
+}
+
+// doExecuteVars is like doExecute, but also returns the variable occurrences
+// of the match. The results are nil if there is no match. If c is not nil,
+// the values it records as consumed are not used, and the occurrences of
+// the match are also returned for the caller to record in c if it keeps
+// the match.
+func (re *Regexp) doExecuteVars(c *consumption, b []byte, s string, pos int, ncap int) ([]int, []VarMatch, []varUse) {
+	if !re.hasVar {
+		a := re.doExecute(nil, b, s, pos, ncap, nil)
+		if a == nil {
+			return nil, nil, nil
+		}
+		return a, []VarMatch{}, nil
+	}
+	if len(b)+len(s) < re.minInputLen || re.cannotMatch(b, s, pos) {
+		return nil, nil, nil
+	}
+	bs := re.backtrackStateFrom(c, b, s, pos, ncap)
+	if bs == nil {
+		return nil, nil, nil
+	}
+	var uses []varUse
+	if c != nil {
+		uses = append(uses, bs.matchUses...)
+	}
+	a := append([]int(nil), bs.matchcap...)
+	vars := make([]VarMatch, len(bs.matchUses))
+	for i, u := range bs.matchUses {
+		vars[i] = re.varMatch(u, b, s)
+	}
+	freeBitState(bs)
+	return a, vars, uses
+}
+
+// varMatch converts u, recorded while matching b or s, to a VarMatch.
+func (re *Regexp) varMatch(u varUse, b []byte, s string) VarMatch {
+	m := VarMatch{
+		Name:        u.inst.Str,
+		Occurrence:  u.occurrence,
+		Ref:         u.ref,
+		RegexpIndex: -1,
+		Start:       u.start,
+		End:         u.end,
+	}
+	if b != nil {
+		m.Value = string(b[u.start:u.end])
+	} else {
+		m.Value = s[u.start:u.end]
+	}
+	if u.inst.Op == syntax.InstRegVar {
+		m.Kind = RegVarKind
+		m.RegexpIndex = 0
+		for e := re.vars.regVar[m.Name].l.Front(); e != u.elem; e = e.Next() {
+			m.RegexpIndex++
+		}
+		if u.caps != nil {
+			m.SubmatchIndex = u.caps
+			m.Submatch = make([]string, len(u.caps)/2)
+			for i := range m.Submatch {
+				if u.caps[2*i] < 0 {
+					continue
+				}
+				if b != nil {
+					m.Submatch[i] = string(b[u.caps[2*i]:u.caps[2*i+1]])
+				} else {
+					m.Submatch[i] = s[u.caps[2*i]:u.caps[2*i+1]]
+				}
+			}
+			m.SubexpNames = u.elem.Value.(*Regexp).SubexpNames()
+		}
+	}
+	return m
+}
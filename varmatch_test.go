@@ -0,0 +1,83 @@
+package regPlus
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestRegexp_FindStringVarMatches(t *testing.T) {
+	mustCompile := MustCompile("a\\(${word}\\)b\\(${word}\\)cd")
+	mustCompile.RegisterStringVar("word", "abc", "dabc", "abcde")
+
+	assert.Equal(t, mustCompile.FindStringVarMatches("xa(abcde)b(abc)cd e"), []VarMatch{
+		{Name: "word", Kind: StringVarKind, Occurrence: 1, Value: "abcde", RegexpIndex: -1, Start: 3, End: 8},
+		{Name: "word", Kind: StringVarKind, Occurrence: 2, Value: "abc", RegexpIndex: -1, Start: 11, End: 14},
+	})
+	assert.Nil(t, mustCompile.FindStringVarMatches("a(abc)b(abc)cd"))
+
+	// Occurrences on abandoned paths are not reported.
+	alt := MustCompile("${w}x|a${w}y")
+	alt.RegisterStringVar("w", "ab", "b")
+	assert.Equal(t, alt.FindStringVarMatches("aby"), []VarMatch{
+		{Name: "w", Kind: StringVarKind, Occurrence: 1, Value: "b", RegexpIndex: -1, Start: 1, End: 2},
+	})
+
+	assert.Equal(t, MustCompile("a+").FindStringVarMatches("baa"), []VarMatch{})
+
+	ref := MustCompile("<${tag}>x</${=tag}>")
+	ref.RegisterStringVar("tag", "a", "b")
+	assert.Equal(t, ref.FindStringVarMatches("<b>x</b>"), []VarMatch{
+		{Name: "tag", Kind: StringVarKind, Occurrence: 1, Value: "b", RegexpIndex: -1, Start: 1, End: 2},
+		{Name: "tag", Kind: StringVarKind, Occurrence: 1, Ref: true, Value: "b", RegexpIndex: -1, Start: 6, End: 7},
+	})
+
+	// Value is the input text, not the registered string it matched.
+	fold := MustCompile("(?i)<${tag}>")
+	fold.RegisterStringVar("tag", "abc")
+	assert.Equal(t, fold.FindStringVarMatches("<ABC>"), []VarMatch{
+		{Name: "tag", Kind: StringVarKind, Occurrence: 1, Value: "ABC", RegexpIndex: -1, Start: 1, End: 4},
+	})
+}
+
+func TestRegexp_FindStringVarMatches_RegVar(t *testing.T) {
+	mustCompile := MustCompile("a@{var}2b@{var}")
+	mustCompile.RegisterRegVar("var", MustCompile("\\d+"), MustCompile("[a-z]*"))
+
+	assert.Equal(t, mustCompile.FindStringVarMatches("a502q302bacR"), []VarMatch{
+		{Name: "var", Kind: RegVarKind, Occurrence: 1, Value: "502q30", RegexpIndex: 0, Start: 1, End: 7},
+		{Name: "var", Kind: RegVarKind, Occurrence: 2, Value: "ac", RegexpIndex: 1, Start: 9, End: 11},
+	})
+}
+
+func TestRegexp_FindAllStringVarMatches(t *testing.T) {
+	mustCompile := MustCompile("\\(${word}\\)")
+	mustCompile.RegisterStringVar("word", []string{"hello", "hallo", "world", "aad", "aqw"}...)
+
+	all := mustCompile.FindAllStringVarMatches("a(aad)b(hallo)cd(x)fg", -1)
+	assert.Equal(t, len(all), 2)
+	assert.Equal(t, all[0][0].Value, "aad")
+	assert.Equal(t, all[1][0].Value, "hallo")
+	assert.Equal(t, all[1][0].Start, 8)
+	assert.Nil(t, mustCompile.FindAllStringVarMatches("nothing", -1))
+}
+
+func TestRegexp_FindStringVarMatches_RegVarGroups(t *testing.T) {
+	mustCompile := MustCompile(`on @{date}, \((@{date})\)`)
+	date := `(?P<year>\d{4})-(?P<month>\d\d)(-\d\d)?`
+	mustCompile.RegisterRegVar("date", MustCompile(date), MustCompile(date))
+
+	matches := mustCompile.FindStringVarMatches("x on 2023-01-05, (2024-12)")
+	assert.Equal(t, matches, []VarMatch{
+		{Name: "date", Kind: RegVarKind, Occurrence: 1, Value: "2023-01-05", RegexpIndex: 0, Start: 5, End: 15,
+			Submatch: []string{"2023-01-05", "2023", "01", "-05"}, SubmatchIndex: []int{5, 15, 5, 9, 10, 12, 12, 15},
+			SubexpNames: []string{"", "year", "month", ""}},
+		{Name: "date", Kind: RegVarKind, Occurrence: 2, Value: "2024-12", RegexpIndex: 1, Start: 18, End: 25,
+			Submatch: []string{"2024-12", "2024", "12", ""}, SubmatchIndex: []int{18, 25, 18, 22, 23, 25, -1, -1},
+			SubexpNames: []string{"", "year", "month", ""}},
+	})
+	assert.Equal(t, matches[1].SubmatchByName("year"), "2024")
+	assert.Equal(t, matches[1].SubmatchByName("day"), "")
+
+	// Groups of the outer pattern are unaffected.
+	assert.Equal(t, mustCompile.FindStringSubmatch("x on 2023-01-05, (2024-12)"), []string{"on 2023-01-05, (2024-12)", "2024-12"})
+}
@@ -0,0 +1,488 @@
+package regPlus
+
+import (
+	"github.com/koleter/regPlus/syntax"
+	"math"
+	"sort"
+	"strconv"
+)
+
+// A VarInfo describes a variable used by a pattern.
+type VarInfo struct {
+	Name string
+	Kind VarKind
+
+	// Occurrences is the number of times the variable is written in the
+	// pattern, not counting ${=name} references, which are counted by Refs.
+	Occurrences int
+	Refs        int
+
+	// MaxUses is the largest number of times a match can use the
+	// variable, or -1 if repetitions make it unbounded.
+	MaxUses int
+}
+
+// A VarError reports a variable that the pattern cannot match with,
+// because it is unregistered or its limits cannot be satisfied.
+type VarError struct {
+	Name string
+	Kind VarKind
+	Msg  string
+}
+
+func (e *VarError) Error() string {
+	kind := "string var "
+	if e.Kind == RegVarKind {
+		kind = "reg var "
+	}
+	return "regexp: " + kind + e.Name + " " + e.Msg
+}
+
+// Vars returns the variables used by the pattern, in order of their
+// first appearance.
+func (re *Regexp) Vars() []VarInfo {
+	return append([]VarInfo(nil), re.varInfo...)
+}
+
+// Validate reports whether re can be matched with its registered variables.
+// It returns a *VarError if a variable used by the pattern is unregistered,
+// which would make matching panic, or if re can never match because a
+// ${=name} reference has nothing to repeat or the lower limit of a variable
+// exceeds the number of times the pattern can use it or the number of
+// values registered on it. The Regexps registered on the reg variables of
+// the pattern are validated too, as matching would use them.
+func (re *Regexp) Validate() error {
+	return re.validate(map[*Regexp]bool{})
+}
+
+// validate is Validate for a Regexp that may be registered on its own reg
+// variables, directly or not; seen holds the Regexps validated so far.
+func (re *Regexp) validate(seen map[*Regexp]bool) error {
+	if seen[re] {
+		return nil
+	}
+	seen[re] = true
+	maxUses := map[varKey]int{}
+	for _, v := range re.varInfo {
+		op := syntax.OpStringVar
+		registered := re.vars.stringVar[v.Name] != nil
+		if v.Kind == RegVarKind {
+			op = syntax.OpRegVar
+			registered = re.vars.regVar[v.Name] != nil
+		}
+		if !registered {
+			return &VarError{Name: v.Name, Kind: v.Kind, Msg: "is unregistered"}
+		}
+		if v.Refs > 0 && v.MaxUses == 0 {
+			return &VarError{Name: v.Name, Kind: v.Kind, Msg: "is referenced by ${=" + v.Name + "} but never bound"}
+		}
+		maxUses[varKey{op, v.Name}] = v.MaxUses
+	}
+
+	names := make([]string, 0, len(re.vars.stringVar))
+	for name := range re.vars.stringVar {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		t := re.vars.stringVar[name]
+		min, _ := re.stringVarLimit(name, t)
+		values := math.MaxInt64
+		if t.mode == Consuming && min > 0 {
+			// Counting the values walks the trie; only a lower
+			// limit needs them.
+			values = t.root.total()
+		}
+		if err := checkMin(name, StringVarKind, min, maxUses[varKey{syntax.OpStringVar, name}], values); err != nil {
+			return err
+		}
+	}
+
+	names = names[:0]
+	for name := range re.vars.regVar {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		r := re.vars.regVar[name]
+		min, _ := re.regVarLimit(name, r)
+		values := math.MaxInt64
+		if r.mode == Consuming && min > 0 {
+			values = 0
+			for e := r.l.Front(); e != nil && e != &r.l.root; e = e.Next() {
+				values++
+			}
+		}
+		if err := checkMin(name, RegVarKind, min, maxUses[varKey{syntax.OpRegVar, name}], values); err != nil {
+			return err
+		}
+	}
+
+	for _, v := range re.varInfo {
+		if v.Kind != RegVarKind {
+			continue
+		}
+		r := re.vars.regVar[v.Name]
+		for e := r.l.Front(); e != nil && e != &r.l.root; e = e.Next() {
+			if err := e.Value.(*Regexp).validate(seen); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// checkMin reports an error if a variable that must be used min times
+// can be used at most maxUses times (-1 for no bound) by the pattern,
+// or fewer than min values are registered on it.
+func checkMin(name string, kind VarKind, min, maxUses, values int) error {
+	if maxUses >= 0 && min > maxUses {
+		return &VarError{Name: name, Kind: kind, Msg: "must be used " + strconv.Itoa(min) +
+			" times but the pattern uses it at most " + strconv.Itoa(maxUses) + " times"}
+	}
+	if min > values {
+		return &VarError{Name: name, Kind: kind, Msg: "must be used " + strconv.Itoa(min) +
+			" times but has " + strconv.Itoa(values) + " values"}
+	}
+	return nil
+}
+
+// varInfos returns the variables used by re, in order of their first
+// appearance.
+func varInfos(re *syntax.Regexp) []VarInfo {
+	var infos []VarInfo
+	index := map[varKey]int{}
+	var walk func(re *syntax.Regexp)
+	walk = func(re *syntax.Regexp) {
+		switch re.Op {
+		case syntax.OpStringVar, syntax.OpRegVar:
+			k := varKey{re.Op, re.Var}
+			i, ok := index[k]
+			if !ok {
+				i = len(infos)
+				index[k] = i
+				kind := StringVarKind
+				if re.Op == syntax.OpRegVar {
+					kind = RegVarKind
+				}
+				infos = append(infos, VarInfo{Name: re.Var, Kind: kind})
+			}
+			if re.Flags&syntax.VarRef != 0 {
+				infos[i].Refs++
+			} else {
+				infos[i].Occurrences++
+			}
+		}
+		for _, sub := range re.Sub {
+			walk(sub)
+		}
+	}
+	walk(re)
+	for k, i := range index {
+		infos[i].MaxUses = maxVarUses(re, k)
+	}
+	return infos
+}
+
+// maxVarUses returns the largest number of times a match of re can use
+// the variable k, or -1 if it is unbounded.
+func maxVarUses(re *syntax.Regexp, k varKey) int {
+	switch re.Op {
+	case syntax.OpStringVar, syntax.OpRegVar:
+		if re.Op == k.op && re.Var == k.name && re.Flags&syntax.VarRef == 0 {
+			return 1
+		}
+		return 0
+	case syntax.OpCapture, syntax.OpQuest:
+		return maxVarUses(re.Sub[0], k)
+	case syntax.OpStar, syntax.OpPlus, syntax.OpRepeat:
+		n := maxVarUses(re.Sub[0], k)
+		switch {
+		case n == 0:
+			return 0
+		case n < 0 || re.Op != syntax.OpRepeat || re.Max < 0:
+			return -1
+		}
+		return n * re.Max
+	case syntax.OpConcat:
+		total := 0
+		for _, sub := range re.Sub {
+			n := maxVarUses(sub, k)
+			if n < 0 {
+				return -1
+			}
+			total += n
+		}
+		return total
+	case syntax.OpAlternate:
+		max := 0
+		for _, sub := range re.Sub {
+			n := maxVarUses(sub, k)
+			if n < 0 {
+				return -1
+			}
+			if n > max {
+				max = n
+			}
+		}
+		return max
+	}
+	return 0
+}
+
+// The Err variants below are like the methods they are named after, but
+// return the error of Validate instead of matching if the variables of re
+// are not usable, and ErrBudgetExceeded instead of reporting no match if
+// a search is stopped by the match budget.
+
+// MatchStringErr is the Err variant of MatchString.
+func (re *Regexp) MatchStringErr(s string) (bool, error) {
+	var matched bool
+	if err := re.matchErr(func(m *Regexp) { matched = m.MatchString(s) }); err != nil {
+		return false, err
+	}
+	return matched, nil
+}
+
+// MatchErr is the Err variant of Match.
+func (re *Regexp) MatchErr(b []byte) (bool, error) {
+	var matched bool
+	if err := re.matchErr(func(m *Regexp) { matched = m.Match(b) }); err != nil {
+		return false, err
+	}
+	return matched, nil
+}
+
+// FindStringErr is the Err variant of FindString.
+func (re *Regexp) FindStringErr(s string) (string, error) {
+	var str string
+	if err := re.matchErr(func(m *Regexp) { str = m.FindString(s) }); err != nil {
+		return "", err
+	}
+	return str, nil
+}
+
+// FindStringIndexErr is the Err variant of FindStringIndex.
+func (re *Regexp) FindStringIndexErr(s string) ([]int, error) {
+	var loc []int
+	if err := re.matchErr(func(m *Regexp) { loc = m.FindStringIndex(s) }); err != nil {
+		return nil, err
+	}
+	return loc, nil
+}
+
+// FindStringSubmatchErr is the Err variant of FindStringSubmatch.
+func (re *Regexp) FindStringSubmatchErr(s string) ([]string, error) {
+	var a []string
+	if err := re.matchErr(func(m *Regexp) { a = m.FindStringSubmatch(s) }); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// FindAllStringErr is the Err variant of FindAllString.
+func (re *Regexp) FindAllStringErr(s string, n int) ([]string, error) {
+	var a []string
+	if err := re.matchErr(func(m *Regexp) { a = m.FindAllString(s, n) }); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// FindStringVarMatchesErr is the Err variant of FindStringVarMatches.
+func (re *Regexp) FindStringVarMatchesErr(s string) ([]VarMatch, error) {
+	var vars []VarMatch
+	if err := re.matchErr(func(m *Regexp) { vars = m.FindStringVarMatches(s) }); err != nil {
+		return nil, err
+	}
+	return vars, nil
+}
+
+// ReplaceAllStringErr is the Err variant of ReplaceAllString.
+func (re *Regexp) ReplaceAllStringErr(src, repl string) (string, error) {
+	var out string
+	if err := re.matchErr(func(m *Regexp) { out = m.ReplaceAllString(src, repl) }); err != nil {
+		return "", err
+	}
+	return out, nil
+}
+
+// FindErr is the Err variant of Find.
+func (re *Regexp) FindErr(b []byte) ([]byte, error) {
+	var a []byte
+	if err := re.matchErr(func(m *Regexp) { a = m.Find(b) }); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// FindIndexErr is the Err variant of FindIndex.
+func (re *Regexp) FindIndexErr(b []byte) ([]int, error) {
+	var loc []int
+	if err := re.matchErr(func(m *Regexp) { loc = m.FindIndex(b) }); err != nil {
+		return nil, err
+	}
+	return loc, nil
+}
+
+// FindSubmatchErr is the Err variant of FindSubmatch.
+func (re *Regexp) FindSubmatchErr(b []byte) ([][]byte, error) {
+	var a [][]byte
+	if err := re.matchErr(func(m *Regexp) { a = m.FindSubmatch(b) }); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// FindSubmatchIndexErr is the Err variant of FindSubmatchIndex.
+func (re *Regexp) FindSubmatchIndexErr(b []byte) ([]int, error) {
+	var a []int
+	if err := re.matchErr(func(m *Regexp) { a = m.FindSubmatchIndex(b) }); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// FindStringSubmatchIndexErr is the Err variant of FindStringSubmatchIndex.
+func (re *Regexp) FindStringSubmatchIndexErr(s string) ([]int, error) {
+	var a []int
+	if err := re.matchErr(func(m *Regexp) { a = m.FindStringSubmatchIndex(s) }); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// FindAllErr is the Err variant of FindAll.
+func (re *Regexp) FindAllErr(b []byte, n int) ([][]byte, error) {
+	var a [][]byte
+	if err := re.matchErr(func(m *Regexp) { a = m.FindAll(b, n) }); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// FindAllIndexErr is the Err variant of FindAllIndex.
+func (re *Regexp) FindAllIndexErr(b []byte, n int) ([][]int, error) {
+	var a [][]int
+	if err := re.matchErr(func(m *Regexp) { a = m.FindAllIndex(b, n) }); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// FindAllStringIndexErr is the Err variant of FindAllStringIndex.
+func (re *Regexp) FindAllStringIndexErr(s string, n int) ([][]int, error) {
+	var a [][]int
+	if err := re.matchErr(func(m *Regexp) { a = m.FindAllStringIndex(s, n) }); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// FindAllSubmatchErr is the Err variant of FindAllSubmatch.
+func (re *Regexp) FindAllSubmatchErr(b []byte, n int) ([][][]byte, error) {
+	var a [][][]byte
+	if err := re.matchErr(func(m *Regexp) { a = m.FindAllSubmatch(b, n) }); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// FindAllSubmatchIndexErr is the Err variant of FindAllSubmatchIndex.
+func (re *Regexp) FindAllSubmatchIndexErr(b []byte, n int) ([][]int, error) {
+	var a [][]int
+	if err := re.matchErr(func(m *Regexp) { a = m.FindAllSubmatchIndex(b, n) }); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// FindAllStringSubmatchErr is the Err variant of FindAllStringSubmatch.
+func (re *Regexp) FindAllStringSubmatchErr(s string, n int) ([][]string, error) {
+	var a [][]string
+	if err := re.matchErr(func(m *Regexp) { a = m.FindAllStringSubmatch(s, n) }); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// FindAllStringSubmatchIndexErr is the Err variant of FindAllStringSubmatchIndex.
+func (re *Regexp) FindAllStringSubmatchIndexErr(s string, n int) ([][]int, error) {
+	var a [][]int
+	if err := re.matchErr(func(m *Regexp) { a = m.FindAllStringSubmatchIndex(s, n) }); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// FindAllStringVarMatchesErr is the Err variant of FindAllStringVarMatches.
+func (re *Regexp) FindAllStringVarMatchesErr(s string, n int) ([][]VarMatch, error) {
+	var vars [][]VarMatch
+	if err := re.matchErr(func(m *Regexp) { vars = m.FindAllStringVarMatches(s, n) }); err != nil {
+		return nil, err
+	}
+	return vars, nil
+}
+
+// FindStringResultErr is the Err variant of FindStringResult.
+func (re *Regexp) FindStringResultErr(s string) (*MatchResult, error) {
+	var res *MatchResult
+	if err := re.matchErr(func(m *Regexp) { res = m.FindStringResult(s) }); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// ReplaceAllLiteralStringErr is the Err variant of ReplaceAllLiteralString.
+func (re *Regexp) ReplaceAllLiteralStringErr(src, repl string) (string, error) {
+	var out string
+	if err := re.matchErr(func(m *Regexp) { out = m.ReplaceAllLiteralString(src, repl) }); err != nil {
+		return "", err
+	}
+	return out, nil
+}
+
+// ReplaceAllStringFuncErr is the Err variant of ReplaceAllStringFunc.
+func (re *Regexp) ReplaceAllStringFuncErr(src string, repl func(string) string) (string, error) {
+	var out string
+	if err := re.matchErr(func(m *Regexp) { out = m.ReplaceAllStringFunc(src, repl) }); err != nil {
+		return "", err
+	}
+	return out, nil
+}
+
+// ReplaceAllErr is the Err variant of ReplaceAll.
+func (re *Regexp) ReplaceAllErr(src, repl []byte) ([]byte, error) {
+	var out []byte
+	if err := re.matchErr(func(m *Regexp) { out = m.ReplaceAll(src, repl) }); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// ReplaceAllLiteralErr is the Err variant of ReplaceAllLiteral.
+func (re *Regexp) ReplaceAllLiteralErr(src, repl []byte) ([]byte, error) {
+	var out []byte
+	if err := re.matchErr(func(m *Regexp) { out = m.ReplaceAllLiteral(src, repl) }); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// ReplaceAllFuncErr is the Err variant of ReplaceAllFunc.
+func (re *Regexp) ReplaceAllFuncErr(src []byte, repl func([]byte) []byte) ([]byte, error) {
+	var out []byte
+	if err := re.matchErr(func(m *Regexp) { out = m.ReplaceAllFunc(src, repl) }); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// SplitErr is the Err variant of Split.
+func (re *Regexp) SplitErr(s string, n int) ([]string, error) {
+	var a []string
+	if err := re.matchErr(func(m *Regexp) { a = m.Split(s, n) }); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
@@ -0,0 +1,181 @@
+package regPlus
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestRegexp_Vars(t *testing.T) {
+	cases := []struct {
+		name   string
+		reg    string
+		expect []VarInfo
+	}{
+		{
+			"No.1", "a+", nil,
+		},
+		{
+			"No.2", "<${tag}>@{body}</${=tag}>", []VarInfo{
+				{Name: "tag", Kind: StringVarKind, Occurrences: 1, Refs: 1, MaxUses: 1},
+				{Name: "body", Kind: RegVarKind, Occurrences: 1, MaxUses: 1},
+			},
+		},
+		{
+			"No.3", "${w}(?:${w}|x${w}${w})?", []VarInfo{
+				{Name: "w", Kind: StringVarKind, Occurrences: 4, MaxUses: 3},
+			},
+		},
+		{
+			"No.4", "(?:${w}${v}){2,3}${v}*", []VarInfo{
+				{Name: "w", Kind: StringVarKind, Occurrences: 1, MaxUses: 3},
+				{Name: "v", Kind: StringVarKind, Occurrences: 2, MaxUses: -1},
+			},
+		},
+		{
+			"No.5", "${w}@{w}", []VarInfo{
+				{Name: "w", Kind: StringVarKind, Occurrences: 1, MaxUses: 1},
+				{Name: "w", Kind: RegVarKind, Occurrences: 1, MaxUses: 1},
+			},
+		},
+	}
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, MustCompile(tt.reg).Vars(), tt.expect)
+		})
+	}
+}
+
+func TestRegexp_Validate(t *testing.T) {
+	cases := []struct {
+		name     string
+		reg      string
+		register func(re *Regexp)
+		expect   string
+	}{
+		{
+			"No.1", "${w}@{v}", func(re *Regexp) {
+				re.RegisterStringVar("w", "a")
+				re.RegisterRegVar("v", MustCompile("b"))
+			}, "",
+		},
+		{
+			"No.2", "${w}@{v}", func(re *Regexp) {
+				re.RegisterStringVar("w", "a")
+			}, "regexp: reg var v is unregistered",
+		},
+		{
+			"No.3", "${=w}", func(re *Regexp) {
+				re.RegisterStringVar("w", "a")
+			}, "regexp: string var w is referenced by ${=w} but never bound",
+		},
+		{
+			"No.4", "${w}x${w}", func(re *Regexp) {
+				re.RegisterStringVar("w", "a", "b", "c")
+				re.SetStringVarLimit("w", 3, 3)
+			}, "regexp: string var w must be used 3 times but the pattern uses it at most 2 times",
+		},
+		{
+			"No.5", "${w:3,}", func(re *Regexp) {
+				re.RegisterStringVar("w", "a", "b")
+			}, "regexp: string var w must be used 3 times but the pattern uses it at most 1 times",
+		},
+		{
+			"No.6", "${w}+", func(re *Regexp) {
+				re.RegisterStringVar("w", "a", "b")
+				re.SetStringVarLimit("w", 3, 4)
+			}, "regexp: string var w must be used 3 times but has 2 values",
+		},
+		{
+			"No.7", "${w}+", func(re *Regexp) {
+				re.RegisterStringVar("w", "a", "b")
+				re.SetStringVarLimit("w", 3, 4)
+				re.SetStringVarMode("w", Reusable)
+			}, "",
+		},
+		{
+			// A required variable missing from the pattern can never be used.
+			"No.8", "a", func(re *Regexp) {
+				re.RegisterRegVar("v", MustCompile("b"))
+				re.SetRegVarLimit("v", 1, 1)
+			}, "regexp: reg var v must be used 1 times but the pattern uses it at most 0 times",
+		},
+	}
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			mustCompile := MustCompile(tt.reg)
+			tt.register(mustCompile)
+			err := mustCompile.Validate()
+			if tt.expect == "" {
+				assert.Nil(t, err)
+			} else {
+				assert.EqualError(t, err, tt.expect)
+			}
+		})
+	}
+}
+
+func TestRegexp_FindStringErr(t *testing.T) {
+	mustCompile := MustCompile("a${w}")
+	s, err := mustCompile.FindStringErr("xab")
+	assert.Equal(t, s, "")
+	assert.Equal(t, err, &VarError{Name: "w", Kind: StringVarKind, Msg: "is unregistered"})
+
+	s, err = mustCompile.With(NewBindings().StringVar("w", "b")).FindStringErr("xab")
+	assert.Equal(t, s, "ab")
+	assert.Nil(t, err)
+
+	matched, err := mustCompile.MatchStringErr("ab")
+	assert.Equal(t, matched, false)
+	assert.NotNil(t, err)
+}
+
+func TestRegexp_ValidateRegVar(t *testing.T) {
+	mustCompile := MustCompile("a@{v}")
+	inner := MustCompile("${x}")
+	mustCompile.RegisterRegVar("v", inner)
+	unregistered := &VarError{Name: "x", Kind: StringVarKind, Msg: "is unregistered"}
+	assert.Equal(t, mustCompile.Validate(), unregistered)
+	s, err := mustCompile.FindStringErr("ab")
+	assert.Equal(t, s, "")
+	assert.Equal(t, err, unregistered)
+
+	inner.RegisterStringVar("x", "b")
+	assert.Nil(t, mustCompile.Validate())
+	s, err = mustCompile.FindStringErr("ab")
+	assert.Equal(t, s, "ab")
+	assert.Nil(t, err)
+
+	// Recursive registrations are validated once.
+	paren := MustCompile("\\(@{paren}\\)|x")
+	paren.RegisterRegVar("paren", paren)
+	paren.SetRegVarMode("paren", Reusable)
+	assert.Nil(t, paren.Validate())
+}
+
+func TestRegexp_AllErrVariants(t *testing.T) {
+	mustCompile := MustCompile("a${w}")
+	unregistered := &VarError{Name: "w", Kind: StringVarKind, Msg: "is unregistered"}
+	b := []byte("xab ab")
+
+	_, err := mustCompile.FindErr(b)
+	assert.Equal(t, err, unregistered)
+	_, err = mustCompile.FindAllIndexErr(b, -1)
+	assert.Equal(t, err, unregistered)
+	_, err = mustCompile.FindAllStringSubmatchErr("xab ab", -1)
+	assert.Equal(t, err, unregistered)
+	_, err = mustCompile.ReplaceAllErr(b, []byte("-"))
+	assert.Equal(t, err, unregistered)
+	_, err = mustCompile.SplitErr("xab ab", -1)
+	assert.Equal(t, err, unregistered)
+
+	mustCompile.RegisterStringVar("w", "b")
+	loc, err := mustCompile.FindAllIndexErr(b, -1)
+	assert.Equal(t, loc, [][]int{{1, 3}, {4, 6}})
+	assert.Nil(t, err)
+	out, err := mustCompile.ReplaceAllErr(b, []byte("-"))
+	assert.Equal(t, string(out), "x- -")
+	assert.Nil(t, err)
+	split, err := mustCompile.SplitErr("xab ab", -1)
+	assert.Equal(t, split, []string{"x", " ", ""})
+	assert.Nil(t, err)
+}
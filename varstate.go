@@ -0,0 +1,133 @@
+package regPlus
+
+import "sync"
+
+// varState records, for a single match, how many times each variable
+// has been used and which of its registered values have been consumed.
+// The registrations themselves (trie nodes, RegNode lists) are only
+// read while matching.
+//
+// fp is a fingerprint of the whole state: it is 0 when no variable use
+// can affect the rest of the match and otherwise identifies the
+// combination of counts, so that
+// the backtracker only treats two visits of (pc, pos) as the same when
+// they were made with the same variables consumed.
+type varState struct {
+	strCount map[*StringTreeNode]int // uses of each string variable
+	used     map[*node]int           // consumed count of each trie value
+	regCount map[*RegNode]int        // uses of each reg variable
+	regUsed  map[*Element]bool       // reg variable values already used
+
+	fp  uint64
+	ids map[interface{}]uint64 // fingerprint ids of the keys above
+}
+
+var varStatePool sync.Pool
+
+func newVarState() *varState {
+	v, ok := varStatePool.Get().(*varState)
+	if !ok {
+		v = &varState{
+			strCount: map[*StringTreeNode]int{},
+			used:     map[*node]int{},
+			regCount: map[*RegNode]int{},
+			regUsed:  map[*Element]bool{},
+			ids:      map[interface{}]uint64{},
+		}
+	}
+	return v
+}
+
+func freeVarState(v *varState) {
+	for k := range v.strCount {
+		delete(v.strCount, k)
+	}
+	for k := range v.used {
+		delete(v.used, k)
+	}
+	for k := range v.regCount {
+		delete(v.regCount, k)
+	}
+	for k := range v.regUsed {
+		delete(v.regUsed, k)
+	}
+	for k := range v.ids {
+		delete(v.ids, k)
+	}
+	v.fp = 0
+	varStatePool.Put(v)
+}
+
+// remaining returns how many more times the value ending at n
+// may be used in this match.
+func (v *varState) remaining(n *node) int {
+	return n.Cnt - v.used[n]
+}
+
+// countStr adds d to the number of uses of the string variable t.
+// The count of a free variable cannot affect the rest of the match
+// and is left out of fp.
+func (v *varState) countStr(t *StringTreeNode, d int, free bool) {
+	old := v.strCount[t]
+	v.strCount[t] = old + d
+	if !free {
+		v.rehash(t, old, old+d)
+	}
+}
+
+// use adds d to the consumed count of the value ending at n.
+func (v *varState) use(n *node, d int) {
+	old := v.used[n]
+	v.used[n] = old + d
+	v.rehash(n, old, old+d)
+}
+
+// countReg adds d to the number of uses of the reg variable r.
+// As in countStr, the count of a free variable is left out of fp.
+func (v *varState) countReg(r *RegNode, d int, free bool) {
+	old := v.regCount[r]
+	v.regCount[r] = old + d
+	if !free {
+		v.rehash(r, old, old+d)
+	}
+}
+
+// useReg marks the reg variable value e as used or unused.
+func (v *varState) useReg(e *Element, used bool) {
+	if used {
+		v.regUsed[e] = true
+		v.rehash(e, 0, 1)
+	} else {
+		delete(v.regUsed, e)
+		v.rehash(e, 1, 0)
+	}
+}
+
+// rehash updates fp for key changing its value from old to new.
+// Every non-zero (key, value) pair contributes a pseudo-random word,
+// combined by xor so that undoing a change restores the previous fp.
+func (v *varState) rehash(key interface{}, old, new int) {
+	id, ok := v.ids[key]
+	if !ok {
+		id = uint64(len(v.ids)) + 1
+		v.ids[key] = id
+	}
+	if old != 0 {
+		v.fp ^= mix(id, old)
+	}
+	if new != 0 {
+		v.fp ^= mix(id, new)
+	}
+}
+
+// mix returns a well-distributed word for the pair (id, n),
+// using the splitmix64 finalizer.
+func mix(id uint64, n int) uint64 {
+	x := id*0x9e3779b97f4a7c15 + uint64(n)
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}